@@ -0,0 +1,77 @@
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/endocode/shelldoc/pkg/junitxml"
+	"github.com/spf13/cobra"
+)
+
+var mergeOutputFile string
+
+// junitCmd groups the subcommands that process JUnit XML files
+var junitCmd = &cobra.Command{
+	Use:   "junit",
+	Short: "Process JUnit XML result files",
+	Long:  `Process JUnit XML result files, like the ones written by "shelldoc run --xml".`,
+}
+
+// junitMergeCmd combines JUnit XML files into one
+var junitMergeCmd = &cobra.Command{
+	Use:   "merge FILE...",
+	Short: "Merge JUnit XML result files",
+	Long: `Merge reads JUnit XML result files, for example of shelldoc runs on multiple CI
+shards, and writes their test suites into one file. Test suites with the same name
+are combined into one.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  executeMerge,
+}
+
+func init() {
+	junitMergeCmd.Flags().StringVarP(&mergeOutputFile, "output", "o", "", "Write the merged results to the specified file (default: stdout)")
+	junitCmd.AddCommand(junitMergeCmd)
+	rootCmd.AddCommand(junitCmd)
+}
+
+func executeMerge(cmd *cobra.Command, args []string) {
+	if err := mergeFiles(args, mergeOutputFile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// mergeFiles merges the JUnit XML input files and writes the result to the output file, or to stdout
+func mergeFiles(inputs []string, output string) error {
+	var documents []junitxml.JUnitTestSuites
+	for _, input := range inputs {
+		file, err := os.Open(input)
+		if err != nil {
+			return fmt.Errorf("unable to open JUnit XML file: %v", err)
+		}
+		document, err := junitxml.Read(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", input, err)
+		}
+		documents = append(documents, document)
+	}
+	var out io.Writer = os.Stdout
+	if len(output) > 0 {
+		file, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+		if err != nil {
+			return fmt.Errorf("unable to open XML output file for writing: %v", err)
+		}
+		defer file.Close()
+		out = file
+	}
+	if err := junitxml.Merge(documents...).Write(out); err != nil {
+		return fmt.Errorf("error writing XML output file: %v", err)
+	}
+	return nil
+}
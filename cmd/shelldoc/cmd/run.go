@@ -26,6 +26,33 @@ func init() {
 	runCmd.Flags().StringVarP(&context.ShellName, "shell", "s", "", "The shell to invoke (default: $SHELL)")
 	runCmd.Flags().BoolVarP(&context.FailureStops, "fail", "f", false, "Stop on the first failure")
 	runCmd.Flags().StringVarP(&context.XMLOutputFile, "xml", "x", "", "Write results to the specified output file in JUnitXML format")
+	runCmd.Flags().StringVar(&context.TAPOutputFile, "tap", "", "Write results to the specified output file in TAP format")
+	runCmd.Flags().StringVar(&context.JSONOutputFile, "json", "", "Write results to the specified output file in JSON format")
+	runCmd.Flags().StringVar(&context.HTMLOutputFile, "html", "", "Write results to the specified output file as a self-contained HTML document")
+	runCmd.Flags().StringVar(&context.CodeQualityFile, "gitlab-codequality", "", "Write failures to the specified output file as a GitLab Code Quality report")
+	runCmd.Flags().BoolVar(&context.JSONStream, "json-stream", false, "Write newline-delimited JSON events to stdout instead of the console output")
+	runCmd.Flags().DurationVarP(&context.Timeout, "timeout", "t", 0, "Abort commands that run longer than the timeout (default: no timeout)")
+	runCmd.Flags().BoolVarP(&context.Update, "update", "u", false, "Replace mismatching expected responses in the input files with the actual output")
+	runCmd.Flags().StringVar(&context.Format, "format", run.FormatText, "The format of the console output (text, github)")
+	runCmd.Flags().StringVar(&context.Color, "color", run.ColorAuto, "Color the differences between expected and actual output (auto, always, never)")
+	runCmd.Flags().StringArrayVarP(&context.Prompts, "prompt", "p", nil, "A prompt that marks commands, like \"#\" or a regular expression like \"/\\w+@\\w+:\\S*\\$/\" (repeatable, default: $ and >)")
+	runCmd.Flags().StringArrayVar(&context.RootPrompts, "root-prompt", nil, "A prompt that marks commands that require root privileges (repeatable)")
+	runCmd.Flags().StringVar(&context.RootMode, "root", run.RootModeRun, "How to execute commands that require root privileges (run, skip, sudo)")
+	runCmd.Flags().IntVarP(&context.Jobs, "jobs", "j", 1, "The number of files to execute concurrently")
+	runCmd.Flags().BoolVar(&context.Sandbox, "sandbox", false, "Execute every file in a new temporary directory")
+	runCmd.Flags().StringVar(&context.Fixtures, "fixtures", "", "Copy the content of this directory into the sandbox of every file (implies --sandbox)")
+	runCmd.Flags().BoolVar(&context.KeepSandbox, "keep-sandbox", false, "Do not delete the sandbox directories after the run")
+	runCmd.Flags().BoolVar(&context.CleanEnv, "clean-env", false, "Start the shell with a clean environment instead of inheriting it")
+	runCmd.Flags().StringArrayVar(&context.PassEnv, "pass-env", nil, "A variable that is passed through into a clean environment (repeatable, default: HOME, PATH, TMPDIR and USER)")
+	runCmd.Flags().StringArrayVarP(&context.Env, "env", "e", nil, "Set a variable in the environment of the shell, as KEY=VALUE (repeatable)")
+	runCmd.Flags().StringVar(&context.EnvFile, "env-file", "", "Read variables for the environment of the shell from a file with KEY=VALUE lines")
+	runCmd.Flags().StringVar(&context.Container, "container", "", "Execute the shell of every file in a new container of this image")
+	runCmd.Flags().StringVar(&context.ContainerRuntime, "container-runtime", "", "The container runtime to use with --container (default: docker or podman, whichever is installed)")
+	runCmd.Flags().StringVar(&context.SSH, "ssh", "", "Execute the commands on a remote host, like user@host, using the ssh client")
+	runCmd.Flags().StringArrayVar(&context.SSHOptions, "ssh-option", nil, "An option for the ssh client, like \"Port=2222\" (repeatable)")
+	runCmd.Flags().BoolVar(&context.PTY, "pty", false, "Execute the commands on a pseudo-terminal instead of pipes")
+	runCmd.Flags().BoolVar(&context.StripANSI, "strip-ansi", true, "Remove ANSI escape sequences like colors from the output of commands executed on a pseudo-terminal")
+	runCmd.Flags().BoolVar(&context.RestartShell, "restart", false, "Start a new shell if a command exits the shell, and execute the setup scripts again")
 	runCmd.Flags().BoolVarP(&context.ReplaceDots, "replace-dots-in-xml-classname", "d", true, "When using filenames as classnames, replace dots with a unicode circle")
 	rootCmd.AddCommand(runCmd)
 }
@@ -0,0 +1,119 @@
+// Package ci formats test results for continuous integration systems, as GitHub Actions workflow commands
+// and as GitLab Code Quality reports. Both show failures on the lines of the Markdown files they occurred in.
+package ci
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// LevelError is the level of annotations of failed tests
+const LevelError = "error"
+
+// Annotation is a message attached to a range of lines of a file.
+type Annotation struct {
+	// Level is the kind of workflow command, like LevelError
+	Level string
+	// File is the path of the file
+	File string
+	// Line and EndLine are the first and last line of the range, starting at 1 (zero if unknown)
+	Line    int
+	EndLine int
+	// Title is a short summary of the message
+	Title string
+	// Message is the message, it may span multiple lines
+	Message string
+}
+
+// GitHub returns the annotation as a GitHub Actions workflow command, like "::error file=README.md,line=5::message".
+// See https://docs.github.com/en/actions/using-workflows/workflow-commands-for-github-actions for the format.
+func (annotation Annotation) GitHub() string {
+	var properties []string
+	if len(annotation.File) > 0 {
+		properties = append(properties, "file="+escapeProperty(annotation.File))
+		if annotation.Line > 0 {
+			properties = append(properties, fmt.Sprintf("line=%d", annotation.Line))
+			if annotation.EndLine > annotation.Line {
+				properties = append(properties, fmt.Sprintf("endLine=%d", annotation.EndLine))
+			}
+		}
+	}
+	if len(annotation.Title) > 0 {
+		properties = append(properties, "title="+escapeProperty(annotation.Title))
+	}
+	command := "::" + annotation.Level
+	if len(properties) > 0 {
+		command += " " + strings.Join(properties, ",")
+	}
+	return command + "::" + escapeData(annotation.Message)
+}
+
+// escapeData escapes the message of a workflow command
+func escapeData(text string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(text)
+}
+
+// escapeProperty escapes the value of a property of a workflow command
+func escapeProperty(text string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(text)
+}
+
+// Severities of Code Quality issues
+const (
+	// SeverityMajor marks a failed test
+	SeverityMajor = "major"
+	// SeverityCritical marks an error, like a command that could not be executed
+	SeverityCritical = "critical"
+)
+
+// Issue is an entry of a GitLab Code Quality report.
+// See https://docs.gitlab.com/ee/ci/testing/code_quality.html#implement-a-custom-tool for the format.
+type Issue struct {
+	// Description is a one-line description of the issue
+	Description string `json:"description"`
+	// CheckName identifies the kind of issue
+	CheckName string `json:"check_name"`
+	// Fingerprint identifies the issue across runs, it is computed by Write if it is empty
+	Fingerprint string `json:"fingerprint"`
+	// Severity is SeverityMajor or SeverityCritical
+	Severity string `json:"severity"`
+	// Location is the position of the issue
+	Location Location `json:"location"`
+}
+
+// Location is the position of a Code Quality issue.
+type Location struct {
+	Path  string `json:"path"`
+	Lines Lines  `json:"lines"`
+}
+
+// Lines is the line of a Code Quality issue, starting at 1.
+type Lines struct {
+	Begin int `json:"begin"`
+}
+
+// WriteCodeQuality writes the issues as a GitLab Code Quality report.
+// Issues without a fingerprint get one computed from their check name, path, line and description.
+func WriteCodeQuality(w io.Writer, issues []Issue) error {
+	report := make([]Issue, 0, len(issues))
+	for _, issue := range issues {
+		if len(issue.Fingerprint) == 0 {
+			hash := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%s", issue.CheckName, issue.Location.Path,
+				issue.Location.Lines.Begin, issue.Description)))
+			issue.Fingerprint = hex.EncodeToString(hash[:16])
+		}
+		report = append(report, issue)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
@@ -0,0 +1,52 @@
+package ci
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitHub(t *testing.T) {
+	annotation := Annotation{
+		Level:   LevelError,
+		File:    "docs/a,b.md",
+		Line:    5,
+		EndLine: 7,
+		Title:   "echo 100%: FAIL (mismatch)",
+		Message: "-Hello\n+World",
+	}
+	require.Equal(t, "::error file=docs/a%2Cb.md,line=5,endLine=7,title=echo 100%25%3A FAIL (mismatch)::-Hello%0A+World",
+		annotation.GitHub(), "Properties and the message are escaped")
+	require.Equal(t, "::error file=README.md::message", Annotation{Level: LevelError, File: "README.md", Message: "message"}.GitHub(),
+		"Unknown lines are omitted")
+	require.Equal(t, "::error file=README.md,line=3::", Annotation{Level: LevelError, File: "README.md", Line: 3, EndLine: 3}.GitHub(),
+		"The end line is only given for ranges")
+}
+
+func TestCodeQuality(t *testing.T) {
+	issues := []Issue{
+		{Description: "echo Hello: FAIL (mismatch)", CheckName: "shelldoc-fail", Severity: SeverityMajor,
+			Location: Location{Path: "README.md", Lines: Lines{Begin: 12}}},
+		{Description: "echo Hello: FAIL (mismatch)", CheckName: "shelldoc-fail", Severity: SeverityMajor,
+			Location: Location{Path: "README.md", Lines: Lines{Begin: 20}}},
+	}
+	var buffer bytes.Buffer
+	require.NoError(t, WriteCodeQuality(&buffer, issues), "Writing the report should work")
+	var decoded []map[string]interface{}
+	require.NoError(t, json.Unmarshal(buffer.Bytes(), &decoded), "The report should be valid JSON")
+	require.Len(t, decoded, 2)
+	require.Equal(t, "shelldoc-fail", decoded[0]["check_name"])
+	require.Equal(t, map[string]interface{}{"path": "README.md", "lines": map[string]interface{}{"begin": float64(12)}}, decoded[0]["location"])
+	require.NotEmpty(t, decoded[0]["fingerprint"], "The fingerprint is computed")
+	require.NotEqual(t, decoded[0]["fingerprint"], decoded[1]["fingerprint"], "Issues on different lines have different fingerprints")
+
+	buffer.Reset()
+	require.NoError(t, WriteCodeQuality(&buffer, nil), "Writing an empty report should work")
+	require.Equal(t, "[]\n", buffer.String(), "An empty report is an empty list")
+}
@@ -0,0 +1,225 @@
+package diff
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// Equal marks a line that is contained in both the expected and the actual lines
+	Equal = iota
+	// Delete marks a line that is only contained in the expected lines
+	Delete
+	// Insert marks a line that is only contained in the actual lines
+	Insert
+)
+
+// Line is a single line of a diff
+type Line struct {
+	// Operation is one of Equal, Delete or Insert
+	Operation int
+	// Text contains the line, taken from the expected lines for Equal and Delete, and from the actual lines for Insert
+	Text string
+}
+
+// EqualFunc decides whether an expected line is equal to an actual line
+type EqualFunc func(expected, actual string) bool
+
+// MaxCells limits the size of the table that Compute uses to find the common lines of the expected and actual lines,
+// which grows with the product of their numbers. Above the limit, the lines between the common lines at the beginning
+// and at the end are reported as deleted and inserted, without searching for common lines between them.
+const MaxCells = 1 << 22
+
+// Compute calculates the shortest sequence of edits that turns the expected lines into the actual lines
+// If equal is nil, lines are compared literally. For very long inputs, the result may not be the shortest (see MaxCells).
+func Compute(expected, actual []string, equal EqualFunc) []Line {
+	if equal == nil {
+		equal = func(a, b string) bool { return a == b }
+	}
+	// lines that are equal at the beginning and at the end are part of the shortest diff, they need no table
+	prefix := 0
+	for prefix < len(expected) && prefix < len(actual) && equal(expected[prefix], actual[prefix]) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(expected)-prefix && suffix < len(actual)-prefix &&
+		equal(expected[len(expected)-1-suffix], actual[len(actual)-1-suffix]) {
+		suffix++
+	}
+	var result []Line
+	for _, line := range expected[:prefix] {
+		result = append(result, Line{Equal, line})
+	}
+	result = append(result, compute(expected[prefix:len(expected)-suffix], actual[prefix:len(actual)-suffix], equal)...)
+	for _, line := range expected[len(expected)-suffix:] {
+		result = append(result, Line{Equal, line})
+	}
+	return result
+}
+
+// compute calculates the differences using the longest common subsequence of the lines
+func compute(expected, actual []string, equal EqualFunc) []Line {
+	var result []Line
+	if (len(expected)+1)*(len(actual)+1) > MaxCells {
+		for _, line := range expected {
+			result = append(result, Line{Delete, line})
+		}
+		for _, line := range actual {
+			result = append(result, Line{Insert, line})
+		}
+		return result
+	}
+	// lengths[i][j] contains the length of the longest common subsequence of expected[i:] and actual[j:]
+	lengths := make([][]int, len(expected)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(actual)+1)
+	}
+	for i := len(expected) - 1; i >= 0; i-- {
+		for j := len(actual) - 1; j >= 0; j-- {
+			if equal(expected[i], actual[j]) {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(expected) && j < len(actual) {
+		if equal(expected[i], actual[j]) {
+			result = append(result, Line{Equal, expected[i]})
+			i++
+			j++
+		} else if lengths[i+1][j] >= lengths[i][j+1] {
+			result = append(result, Line{Delete, expected[i]})
+			i++
+		} else {
+			result = append(result, Line{Insert, actual[j]})
+			j++
+		}
+	}
+	for ; i < len(expected); i++ {
+		result = append(result, Line{Delete, expected[i]})
+	}
+	for ; j < len(actual); j++ {
+		result = append(result, Line{Insert, actual[j]})
+	}
+	return result
+}
+
+// HasChanges returns true if the diff contains any deleted or inserted lines
+func HasChanges(lines []Line) bool {
+	for _, line := range lines {
+		if line.Operation != Equal {
+			return true
+		}
+	}
+	return false
+}
+
+// Unified formats the diff in the unified diff format, with the given number of lines of context around the changes
+// If the diff contains no changes, the result is empty.
+func Unified(lines []Line, context int) []string {
+	if !HasChanges(lines) {
+		return nil
+	}
+	result := []string{"--- expected", "+++ actual"}
+	// positions of each line in the expected and actual lines, starting at 1
+	expectedLine := make([]int, len(lines)+1)
+	actualLine := make([]int, len(lines)+1)
+	expectedLine[0], actualLine[0] = 1, 1
+	for index, line := range lines {
+		expectedLine[index+1], actualLine[index+1] = expectedLine[index], actualLine[index]
+		if line.Operation != Insert {
+			expectedLine[index+1]++
+		}
+		if line.Operation != Delete {
+			actualLine[index+1]++
+		}
+	}
+	for index := 0; index < len(lines); {
+		if lines[index].Operation == Equal {
+			index++
+			continue
+		}
+		// a hunk begins with up to context equal lines before the first change...
+		first := index - context
+		if first < 0 {
+			first = 0
+		}
+		// ...and ends when more than twice the context of equal lines follow the last change
+		last := index
+		for cursor := index; cursor < len(lines); cursor++ {
+			if lines[cursor].Operation != Equal {
+				last = cursor
+			} else if cursor-last > 2*context {
+				break
+			}
+		}
+		end := last + context + 1
+		if end > len(lines) {
+			end = len(lines)
+		}
+		result = append(result, fmt.Sprintf("@@ -%s +%s @@",
+			hunkRange(expectedLine[first], expectedLine[end]-expectedLine[first]),
+			hunkRange(actualLine[first], actualLine[end]-actualLine[first])))
+		for _, line := range lines[first:end] {
+			result = append(result, prefix(line.Operation)+line.Text)
+		}
+		index = end
+	}
+	return result
+}
+
+// hunkRange formats the start and length of a hunk like GNU diff does
+func hunkRange(start, length int) string {
+	if length == 0 {
+		start-- // an empty range refers to the line before it
+	}
+	if length == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, length)
+}
+
+func prefix(operation int) string {
+	switch operation {
+	case Delete:
+		return "-"
+	case Insert:
+		return "+"
+	default:
+		return " "
+	}
+}
+
+// Colorize adds terminal color codes to the lines of a unified diff
+func Colorize(unified []string) []string {
+	const (
+		red   = "\x1b[31m"
+		green = "\x1b[32m"
+		cyan  = "\x1b[36m"
+		bold  = "\x1b[1m"
+		reset = "\x1b[0m"
+	)
+	var result []string
+	for _, line := range unified {
+		switch {
+		case strings.HasPrefix(line, "---") || strings.HasPrefix(line, "+++"):
+			line = bold + line + reset
+		case strings.HasPrefix(line, "@@"):
+			line = cyan + line + reset
+		case strings.HasPrefix(line, "-"):
+			line = red + line + reset
+		case strings.HasPrefix(line, "+"):
+			line = green + line + reset
+		}
+		result = append(result, line)
+	}
+	return result
+}
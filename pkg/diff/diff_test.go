@@ -0,0 +1,59 @@
+package diff
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompute(t *testing.T) {
+	lines := Compute([]string{"a", "b", "c"}, []string{"a", "x", "c", "d"}, nil)
+	require.Equal(t, []Line{{Equal, "a"}, {Delete, "b"}, {Insert, "x"}, {Equal, "c"}, {Insert, "d"}}, lines)
+	require.True(t, HasChanges(lines), "The lines differ")
+	require.False(t, HasChanges(Compute([]string{"a"}, []string{"a"}, nil)), "The lines are equal")
+}
+
+func TestComputeEqualFunc(t *testing.T) {
+	ignoreCase := func(expected, actual string) bool { return strings.EqualFold(expected, actual) }
+	lines := Compute([]string{"Hello"}, []string{"HELLO"}, ignoreCase)
+	require.False(t, HasChanges(lines), "The lines are equal when ignoring case")
+}
+
+func TestUnified(t *testing.T) {
+	expected := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12"}
+	actual := []string{"1", "2", "three", "4", "5", "6", "7", "8", "9", "10", "11", "12", "13"}
+	unified := Unified(Compute(expected, actual, nil), 2)
+	require.Equal(t, []string{
+		"--- expected",
+		"+++ actual",
+		"@@ -1,5 +1,5 @@",
+		" 1", " 2", "-3", "+three", " 4", " 5",
+		"@@ -11,2 +11,3 @@",
+		" 11", " 12", "+13",
+	}, unified)
+	require.Empty(t, Unified(Compute(expected, expected, nil), 2), "Equal lines produce no diff")
+	require.Equal(t, "@@ -0,0 +1 @@", Unified(Compute(nil, []string{"new"}, nil), 3)[2], "Empty ranges refer to the line before")
+}
+
+func TestComputeLargeInput(t *testing.T) {
+	// Inputs above MaxCells are not compared line by line, but the common beginning and end are still found.
+	var expected, actual []string
+	for index := 0; index < 5000; index++ {
+		expected = append(expected, fmt.Sprintf("expected %d", index))
+		actual = append(actual, fmt.Sprintf("actual %d", index))
+	}
+	expected = append([]string{"first"}, append(expected, "last")...)
+	actual = append([]string{"first"}, append(actual, "last")...)
+	lines := Compute(expected, actual, nil)
+	require.Len(t, lines, 10002, "Every line is contained in the diff once")
+	require.Equal(t, Line{Equal, "first"}, lines[0], "The common beginning is found")
+	require.Equal(t, Line{Delete, "expected 0"}, lines[1], "The expected lines are deleted")
+	require.Equal(t, Line{Insert, "actual 0"}, lines[5001], "The actual lines are inserted")
+	require.Equal(t, Line{Equal, "last"}, lines[10001], "The common end is found")
+}
@@ -0,0 +1,405 @@
+// Package htmlreport writes test results as a self-contained HTML document.
+// Every Markdown file is rendered with its prose, and the results of the commands are shown after the code
+// blocks they were read from. The document does not reference any external resources.
+package htmlreport
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/russross/blackfriday.v2"
+)
+
+// Report contains the results of a run of shelldoc.
+type Report struct {
+	// Title is the title of the document
+	Title string
+	// Version is the version of shelldoc that created the report
+	Version string
+	// Result is the overall result of the run (SUCCESS, FAILURE or ERROR)
+	Result string
+	// Files contains the results of every file
+	Files []File
+}
+
+// File contains the Markdown source of a file and the results of its interactions.
+type File struct {
+	Filename  string
+	Result    string
+	Tests     int
+	Successes int
+	Failures  int
+	Errors    int
+	Skipped   int
+	Duration  time.Duration
+	// Source is the Markdown source of the file
+	Source []byte
+	// Interactions contains the executed interactions, they are shown after the code blocks they were read from
+	Interactions []Interaction
+}
+
+// Interaction contains a command and the result of executing it.
+type Interaction struct {
+	Caption     string
+	Command     string
+	Expected    []string
+	Output      []string
+	ErrorOutput []string
+	ExitCode    int
+	// Result is a human readable description of the result, like "PASS (match)"
+	Result string
+	// Status is one of the Status constants of the jsonreport package, it is used as the CSS class of the badge
+	Status string
+	// Comment explains the result
+	Comment string
+	// Diff is a unified diff of the expected response and the output, if they do not match
+	Diff     []string
+	Duration time.Duration
+	// Line and EndLine are the first and last line of the command and its expected response in the source
+	// Line is zero if the position of the command is unknown.
+	Line    int
+	EndLine int
+}
+
+// block is a range of lines of the Markdown source that contains interactions
+type block struct {
+	first, last  int // line numbers, starting at 1
+	interactions []Interaction
+}
+
+// Write writes the report as an HTML document.
+func (report Report) Write(w io.Writer) error {
+	var page *template.Template
+	page, err := template.New("report").Funcs(template.FuncMap{
+		"seconds": func(duration time.Duration) string { return fmt.Sprintf("%.3fs", duration.Seconds()) },
+		"diffclass": func(line string) string {
+			switch {
+			case strings.HasPrefix(line, "@@"), strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+				return "hunk"
+			case strings.HasPrefix(line, "+"):
+				return "added"
+			case strings.HasPrefix(line, "-"):
+				return "removed"
+			default:
+				return ""
+			}
+		},
+		"anchor":   func(index int) string { return fmt.Sprintf("file-%d", index+1) },
+		"document": func(file File) (template.HTML, error) { return render(file, page) },
+		"lower":    strings.ToLower,
+	}).Parse(pageTemplate)
+	if err != nil {
+		return fmt.Errorf("unable to parse HTML template: %v", err)
+	}
+	if err := page.Execute(w, report); err != nil {
+		return fmt.Errorf("unable to write HTML document: %v", err)
+	}
+	return nil
+}
+
+// render converts the Markdown source of a file into HTML, with the results of the interactions after the blocks
+// they were read from. Raw HTML in the source is omitted, only links to safe protocols are kept.
+// Interactions with an unknown position are added at the end.
+func render(file File, page *template.Template) (template.HTML, error) {
+	lines := strings.SplitAfter(string(file.Source), "\n")
+	renderer := &renderer{
+		html: blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{
+			Flags: blackfriday.CommonHTMLFlags | blackfriday.SkipHTML | blackfriday.Safelink,
+		}),
+		page:   page,
+		lines:  lines,
+		blocks: blocks(lines, file.Interactions),
+	}
+	output := blackfriday.Run(file.Source, blackfriday.WithRenderer(renderer),
+		blackfriday.WithExtensions(blackfriday.CommonExtensions))
+	if renderer.err != nil {
+		return "", renderer.err
+	}
+	var remaining []Interaction
+	for index, block := range renderer.blocks {
+		if !renderer.shown[index] {
+			remaining = append(remaining, block.interactions...)
+		}
+	}
+	var buffer bytes.Buffer
+	buffer.Write(output)
+	if err := renderer.results(&buffer, remaining); err != nil {
+		return "", err
+	}
+	return template.HTML(buffer.String()), nil
+}
+
+// renderer renders a Markdown document to HTML and adds the results of the interactions after the code blocks and
+// HTML comments they were read from. The parser does not report the positions of the nodes, so the renderer searches
+// for their lines in the source in the order in which they are rendered.
+type renderer struct {
+	html   *blackfriday.HTMLRenderer
+	page   *template.Template // contains the template for the results
+	lines  []string           // the Markdown source
+	blocks []block
+	shown  map[int]bool // the indexes of the blocks that were rendered
+	next   int          // the index of the line in the source at which the search for the next node begins
+	err    error
+}
+
+// RenderNode renders a node, and the results of the interactions of the block it contains
+func (renderer *renderer) RenderNode(w io.Writer, node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+	status := renderer.html.RenderNode(w, node, entering)
+	if entering && (node.Type == blackfriday.CodeBlock || node.Type == blackfriday.HTMLBlock) {
+		first, last := renderer.locate(node.Literal)
+		for index, block := range renderer.blocks {
+			if first > 0 && !renderer.shown[index] && block.first <= last && first <= block.last {
+				if renderer.shown == nil {
+					renderer.shown = make(map[int]bool)
+				}
+				renderer.shown[index] = true
+				if err := renderer.results(w, block.interactions); err != nil && renderer.err == nil {
+					renderer.err = err
+				}
+			}
+		}
+	}
+	return status
+}
+
+// RenderHeader is part of the blackfriday.Renderer interface
+func (renderer *renderer) RenderHeader(w io.Writer, ast *blackfriday.Node) {
+	renderer.html.RenderHeader(w, ast)
+}
+
+// RenderFooter is part of the blackfriday.Renderer interface
+func (renderer *renderer) RenderFooter(w io.Writer, ast *blackfriday.Node) {
+	renderer.html.RenderFooter(w, ast)
+}
+
+// results writes the results of the interactions, if there are any
+func (renderer *renderer) results(w io.Writer, interactions []Interaction) error {
+	if len(interactions) == 0 {
+		return nil
+	}
+	return renderer.page.ExecuteTemplate(w, "results", interactions)
+}
+
+// locate finds the lines of the content of a node in the source and returns the first and last line, starting at 1
+// The first non-empty line of the content is searched for, zero is returned if it is not found.
+func (renderer *renderer) locate(literal []byte) (int, int) {
+	content := strings.Split(strings.TrimRight(string(literal), "\n"), "\n")
+	for offset, line := range content {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		for index := renderer.next; index < len(renderer.lines); index++ {
+			if strings.TrimSpace(renderer.lines[index]) == line {
+				renderer.next = index + 1
+				first := index + 1 - offset
+				return first, first + len(content) - 1
+			}
+		}
+		break
+	}
+	return 0, 0
+}
+
+// blocks groups the interactions by the code blocks they were read from, in the order of the source
+// Fenced code blocks are found by their fences. The lines of indented code blocks are the commands and responses,
+// interactions that are only separated by empty lines belong to the same indented code block.
+func blocks(lines []string, interactions []Interaction) []block {
+	fences := enclosedBlocks(lines)
+	var result []*block
+	var unknown block
+	for _, interaction := range interactions {
+		if interaction.Line <= 0 || interaction.Line > len(lines) {
+			unknown.interactions = append(unknown.interactions, interaction)
+			continue
+		}
+		first, last := interaction.Line, max(interaction.Line, min(interaction.EndLine, len(lines)))
+		for _, fence := range fences {
+			if fence[0] <= first && first <= fence[1] {
+				first, last = fence[0], fence[1]
+				break
+			}
+		}
+		found := false
+		for _, existing := range result {
+			if existing.first <= first && first <= existing.last {
+				existing.last = max(existing.last, last)
+				existing.interactions = append(existing.interactions, interaction)
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, &block{first: first, last: last, interactions: []Interaction{interaction}})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].first < result[j].first })
+	var merged []block
+	for _, current := range result {
+		if count := len(merged); count > 0 {
+			previous := &merged[count-1]
+			if current.first <= previous.last || !enclosed(fences, previous.last) && !enclosed(fences, current.first) &&
+				blank(lines[previous.last:current.first-1]) {
+				previous.last = max(previous.last, current.last)
+				previous.interactions = append(previous.interactions, current.interactions...)
+				continue
+			}
+		}
+		merged = append(merged, *current)
+	}
+	if len(unknown.interactions) > 0 {
+		merged = append(merged, unknown)
+	}
+	return merged
+}
+
+// enclosedBlocks returns the first and last lines of the fenced code blocks and the HTML comments in the source,
+// starting at 1. Hidden setup and teardown scripts are HTML comments, they must not be split when rendering.
+func enclosedBlocks(lines []string) [][2]int {
+	var blocks [][2]int
+	closed := func(trimmed string) bool { return false } // matches the end of the current block
+	first := 0
+	for index, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if first > 0 {
+			if closed(trimmed) {
+				blocks = append(blocks, [2]int{first, index + 1})
+				first = 0
+			}
+			continue
+		}
+		for _, marker := range []string{"```", "~~~"} {
+			if strings.HasPrefix(trimmed, marker) {
+				opener := trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, marker[:1]))]
+				closed = func(trimmed string) bool {
+					return strings.HasPrefix(trimmed, opener) && len(strings.Trim(trimmed, opener[:1])) == 0
+				}
+				first = index + 1
+			}
+		}
+		if strings.HasPrefix(trimmed, "<!--") {
+			if strings.Contains(trimmed[4:], "-->") {
+				blocks = append(blocks, [2]int{index + 1, index + 1})
+				continue
+			}
+			closed = func(trimmed string) bool { return strings.Contains(trimmed, "-->") }
+			first = index + 1
+		}
+	}
+	return blocks
+}
+
+// enclosed returns true if the line is part of a fenced code block or an HTML comment
+func enclosed(fences [][2]int, line int) bool {
+	for _, fence := range fences {
+		if fence[0] <= line && line <= fence[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// blank returns true if all lines are empty
+func blank(lines []string) bool {
+	for _, line := range lines {
+		if len(strings.TrimSpace(line)) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+const pageTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: 2em auto; padding: 0 1em; color: #222; line-height: 1.5; }
+pre, code { font-family: monospace; background: #f4f4f4; }
+pre { padding: 0.5em; overflow-x: auto; }
+table.summary { border-collapse: collapse; }
+table.summary th, table.summary td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: right; }
+table.summary th:first-child, table.summary td:first-child { text-align: left; }
+.file { border-top: 3px solid #ccc; margin-top: 3em; }
+.results { margin: 0.5em 0 1.5em 1em; }
+.interaction { border-left: 4px solid #ccc; padding-left: 0.6em; margin-bottom: 0.6em; }
+.interaction pre { margin: 0.2em 0; }
+.badge, .result { display: inline-block; padding: 0 0.5em; border-radius: 0.3em; color: #fff; font-size: 90%; font-weight: bold; background: #888; }
+.badge.pass, .result.success { background: #2a7d2a; }
+.badge.fail, .result.failure { background: #c0392b; }
+.badge.error, .result.error { background: #8e44ad; }
+.interaction.pass { border-color: #2a7d2a; }
+.interaction.fail { border-color: #c0392b; }
+.interaction.error { border-color: #8e44ad; }
+.added { color: #2a7d2a; }
+.removed { color: #c0392b; }
+.hunk { color: #888; }
+.details { color: #555; font-size: 90%; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Result: <span class="result {{lower .Result}}">{{.Result}}</span>, created by shelldoc {{.Version}}</p>
+<table class="summary">
+<tr><th>File</th><th>Result</th><th>Tests</th><th>Successful</th><th>Failures</th><th>Errors</th><th>Skipped</th><th>Duration</th></tr>
+{{- range $index, $file := .Files}}
+<tr><td><a href="#{{anchor $index}}">{{$file.Filename}}</a></td><td><span class="result {{lower $file.Result}}">{{$file.Result}}</span></td><td>{{$file.Tests}}</td><td>{{$file.Successes}}</td><td>{{$file.Failures}}</td><td>{{$file.Errors}}</td><td>{{$file.Skipped}}</td><td>{{seconds $file.Duration}}</td></tr>
+{{- end}}
+</table>
+{{- range $index, $file := .Files}}
+<div class="file" id="{{anchor $index}}">
+<p><span class="result {{lower $file.Result}}">{{$file.Result}}</span> <strong>{{$file.Filename}}</strong>: {{$file.Tests}} tests - {{$file.Successes}} successful, {{$file.Failures}} failures, {{$file.Errors}} errors, {{$file.Skipped}} skipped</p>
+{{document $file}}
+</div>
+{{- end}}
+</body>
+</html>
+{{- define "results"}}
+<div class="results">
+{{- range .}}
+<div class="interaction {{.Status}}">
+<span class="badge {{.Status}}">{{.Result}}</span> <code>{{if .Caption}}{{.Caption}}{{else}}{{.Command}}{{end}}</code>
+<span class="details">{{if .Line}}line {{.Line}}, {{end}}exit code {{.ExitCode}}, {{seconds .Duration}}</span>
+{{- if .Comment}}
+<div class="details">{{.Comment}}</div>
+{{- end}}
+{{- if .Diff}}
+<pre class="diff">{{range .Diff}}<span class="{{diffclass .}}">{{.}}</span>
+{{end}}</pre>
+{{- else if .Output}}
+<pre class="output">{{range .Output}}{{.}}
+{{end}}</pre>
+{{- end}}
+{{- if .ErrorOutput}}
+<pre class="stderr">{{range .ErrorOutput}}{{.}}
+{{end}}</pre>
+{{- end}}
+</div>
+{{- end}}
+</div>
+{{end}}
+`
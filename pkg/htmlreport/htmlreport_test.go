@@ -0,0 +1,94 @@
+package htmlreport
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/endocode/shelldoc/pkg/jsonreport"
+	"github.com/stretchr/testify/require"
+)
+
+const source = `# Title
+
+<!-- shelldoc-setup
+cd /tmp
+-->
+
+Some prose.
+
+` + "```shell" + `
+$ echo Hello
+Hello
+$ echo <b>
+World
+` + "```" + `
+
+An indented code block:
+
+    $ true
+
+    $ false
+
+See [the other file][other], but do not [click](javascript:alert(1)).
+
+<div onclick="alert(1)">Raw HTML</div>
+
+[other]: ./other.md
+`
+
+func TestBlocks(t *testing.T) {
+	lines := strings.SplitAfter(source, "\n")
+	interactions := []Interaction{
+		{Caption: "shelldoc-setup", Line: 3, EndLine: 3},
+		{Command: "echo Hello", Line: 10, EndLine: 11},
+		{Command: "echo <b>", Line: 12, EndLine: 13},
+		{Command: "true", Line: 18, EndLine: 18},
+		{Command: "false", Line: 20, EndLine: 20},
+		{Command: "unknown"},
+	}
+	result := blocks(lines, interactions)
+	require.Len(t, result, 4)
+	require.Equal(t, 3, result[0].first, "The setup script is an HTML comment")
+	require.Equal(t, 5, result[0].last, "The HTML comment is not split")
+	require.Equal(t, 9, result[1].first, "The fenced code block starts at the fence")
+	require.Equal(t, 14, result[1].last, "The fenced code block ends at the fence")
+	require.Len(t, result[1].interactions, 2, "Both commands belong to the fenced code block")
+	require.Equal(t, 18, result[2].first)
+	require.Equal(t, 20, result[2].last, "Commands separated by empty lines belong to the same indented code block")
+	require.Len(t, result[2].interactions, 2)
+	require.Equal(t, 0, result[3].first, "Interactions with unknown positions are collected at the end")
+}
+
+func TestWrite(t *testing.T) {
+	report := Report{Title: "Results", Version: "1.0", Result: "FAILURE", Files: []File{{
+		Filename: "README.md",
+		Result:   "FAILURE",
+		Tests:    2,
+		Source:   []byte(source),
+		Interactions: []Interaction{
+			{Command: "echo Hello", Output: []string{"Hello"}, Result: "PASS (match)", Status: jsonreport.StatusPass, Line: 10, EndLine: 11},
+			{Command: "echo <b>", Output: []string{"<b>"}, Result: "FAIL (mismatch)", Status: jsonreport.StatusFail, Line: 12, EndLine: 13,
+				Diff: []string{"--- expected", "+++ actual", "@@ -1 +1 @@", "-World", "+<b>"}},
+		},
+	}}}
+	var buffer bytes.Buffer
+	require.NoError(t, report.Write(&buffer), "Writing the report should work")
+	document := buffer.String()
+	require.Contains(t, document, `<a href="#file-1">README.md</a>`, "The summary table links to the file")
+	require.Contains(t, document, "<p>Some prose.</p>", "The prose is rendered")
+	require.Contains(t, document, `<span class="badge fail">FAIL (mismatch)</span> <code>echo &lt;b&gt;</code>`, "The results are escaped")
+	require.Contains(t, document, `<span class="removed">-World</span>`, "The diff is shown")
+	require.NotContains(t, document, "<b>", "Output is not interpreted as HTML")
+	require.Contains(t, document, `<a href="./other.md">the other file</a>`, "Reference links are resolved in the whole document")
+	require.NotContains(t, document, "onclick", "Raw HTML in the source is omitted")
+	require.NotContains(t, document, "javascript:", "Only links to safe protocols are kept")
+	require.NotContains(t, document, "http", "The document does not reference external resources")
+	results := strings.Index(document, `<div class="results">`)
+	require.True(t, strings.Index(document, "echo Hello\nHello") < results, "The results follow the code block")
+	require.True(t, results < strings.Index(document, "An indented code block"), "The results precede the following prose")
+}
@@ -0,0 +1,177 @@
+// Package jsonreport writes test results as JSON documents and as a stream of newline-delimited JSON events.
+// The format is versioned using SchemaVersion. Fields may be added within a schema version, but fields are
+// never removed or changed in meaning without incrementing it.
+package jsonreport
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// SchemaVersion is the version of the format of reports and events
+const SchemaVersion = 1
+
+// Values of the Status field of an interaction
+const (
+	// StatusPending means the interaction has not been executed yet
+	StatusPending = "pending"
+	// StatusPass means the output of the command matched the expected response
+	StatusPass = "pass"
+	// StatusFail means the command failed or its output did not match the expected response
+	StatusFail = "fail"
+	// StatusError means the command could not be executed, timed out or exited the shell
+	StatusError = "error"
+	// StatusSkip means the interaction was not executed on purpose
+	StatusSkip = "skip"
+	// StatusExpectedFailure means the interaction is known to be broken and failed as expected
+	StatusExpectedFailure = "xfail"
+)
+
+// Types of events
+const (
+	// EventFileStarted is emitted when the execution of a file begins
+	EventFileStarted = "file-started"
+	// EventInteractionStarted is emitted before an interaction is executed
+	EventInteractionStarted = "interaction-started"
+	// EventInteractionFinished is emitted after an interaction was executed, including its results
+	EventInteractionFinished = "interaction-finished"
+	// EventFileFinished is emitted when all interactions of a file were executed, including the summary of the file
+	EventFileFinished = "file-finished"
+)
+
+// Report contains the results of a run of shelldoc.
+type Report struct {
+	// SchemaVersion is the version of the format
+	SchemaVersion int `json:"schemaVersion"`
+	// Version is the version of shelldoc that created the report
+	Version string `json:"version"`
+	// Result is the overall result of the run (SUCCESS, FAILURE or ERROR)
+	Result string `json:"result"`
+	// Files contains the results of every file, in the order of the command line
+	Files []File `json:"files"`
+}
+
+// File contains the results of executing the interactions of one Markdown file.
+type File struct {
+	// Filename is the path of the Markdown file
+	Filename string `json:"filename"`
+	// Result is the result of the file (SUCCESS, FAILURE or ERROR)
+	Result string `json:"result"`
+	// Tests, Successes, Failures, Errors and Skipped count the interactions by their results
+	Tests     int `json:"tests"`
+	Successes int `json:"successes"`
+	Failures  int `json:"failures"`
+	Errors    int `json:"errors"`
+	Skipped   int `json:"skipped"`
+	// Duration is the time it took to execute the file, in seconds
+	Duration float64 `json:"duration"`
+	// Properties describe the environment the file was executed in
+	Properties map[string]string `json:"properties,omitempty"`
+	// Interactions contains the executed interactions, including setup and teardown scripts, in the order of execution
+	Interactions []Interaction `json:"interactions,omitempty"`
+}
+
+// Interaction contains a command, its expected response and the result of executing it.
+type Interaction struct {
+	// Caption is the descriptive name of the interaction, if it has one
+	Caption string `json:"caption,omitempty"`
+	// Command is the command executed in the shell
+	Command string `json:"command"`
+	// Language is the language of the fenced code block the interaction was read from
+	Language string `json:"language,omitempty"`
+	// Attributes are the shelldoc attributes of the code block
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Expected is the expected response
+	Expected []string `json:"expected"`
+	// Output is the output of the command to stdout
+	Output []string `json:"output"`
+	// ErrorOutput is the output of the command to stderr
+	ErrorOutput []string `json:"errorOutput"`
+	// ExitCode is the exit code of the command
+	ExitCode int `json:"exitCode"`
+	// ResultCode is the result code of the interaction, as defined by the tokenizer package
+	ResultCode int `json:"resultCode"`
+	// Result is a human readable description of the result, like "PASS (match)"
+	Result string `json:"result"`
+	// Status classifies the result, it is one of the Status constants
+	Status string `json:"status"`
+	// Comment explains the result
+	Comment string `json:"comment,omitempty"`
+	// Diff is a unified diff of the expected response and the output, if they do not match
+	Diff []string `json:"diff,omitempty"`
+	// Duration is the time it took to execute the command, in seconds
+	Duration float64 `json:"duration"`
+	// Location is the position of the command in the Markdown source
+	Location Location `json:"location"`
+}
+
+// Location is a position in a Markdown file.
+type Location struct {
+	// Filename is the path of the Markdown file
+	Filename string `json:"filename"`
+	// Line is the first line of the command, starting at 1 (zero if the position is unknown)
+	Line int `json:"line"`
+	// EndLine is the last line of the code block of the command, including the expected response
+	EndLine int `json:"endLine"`
+}
+
+// Event reports the progress of a run.
+type Event struct {
+	// SchemaVersion is the version of the format
+	SchemaVersion int `json:"schemaVersion"`
+	// Type is one of the Event constants
+	Type string `json:"event"`
+	// Time is the time the event occurred
+	Time time.Time `json:"time"`
+	// Filename is the path of the Markdown file the event belongs to
+	Filename string `json:"filename"`
+	// Index is the number of the interaction in the file, starting at 1, for interaction events
+	Index int `json:"index,omitempty"`
+	// Interaction is the interaction for interaction events, before or after it was executed
+	Interaction *Interaction `json:"interaction,omitempty"`
+	// File is the summary of the file for file-finished events, without its interactions
+	File *File `json:"file,omitempty"`
+}
+
+// Write writes the report as an indented JSON document.
+func (report Report) Write(w io.Writer) error {
+	report.SchemaVersion = SchemaVersion
+	if report.Files == nil {
+		report.Files = []File{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// Stream writes events as newline-delimited JSON, one event per line.
+// It is safe to emit events concurrently.
+type Stream struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewStream returns a stream that writes events to w.
+func NewStream(w io.Writer) *Stream {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return &Stream{encoder: encoder}
+}
+
+// Emit writes an event. The schema version is set and the time is set if it is zero.
+func (stream *Stream) Emit(event Event) error {
+	event.SchemaVersion = SchemaVersion
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+	return stream.encoder.Encode(event)
+}
@@ -0,0 +1,81 @@
+package jsonreport
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmptyReport(t *testing.T) {
+	var buffer bytes.Buffer
+	require.NoError(t, Report{Version: "1.0", Result: "SUCCESS"}.Write(&buffer), "Writing an empty report should work")
+	require.JSONEq(t, `{"schemaVersion": 1, "version": "1.0", "result": "SUCCESS", "files": []}`, buffer.String(),
+		"An empty report has the schema version and an empty list of files")
+}
+
+func TestReport(t *testing.T) {
+	report := Report{Version: "1.0", Result: "FAILURE", Files: []File{{
+		Filename: "README.md",
+		Result:   "FAILURE",
+		Tests:    1,
+		Failures: 1,
+		Duration: 0.5,
+		Interactions: []Interaction{{
+			Command:     "echo <World>",
+			Attributes:  map[string]string{"shelldocwhatever": ""},
+			Expected:    []string{"Hello"},
+			Output:      []string{"<World>"},
+			ErrorOutput: []string{},
+			ResultCode:  5,
+			Result:      "FAIL (mismatch)",
+			Status:      StatusFail,
+			Diff:        []string{"@@ -1 +1 @@", "-Hello", "+<World>"},
+			Duration:    0.25,
+			Location:    Location{Filename: "README.md", Line: 12, EndLine: 14},
+		}},
+	}}}
+	var buffer bytes.Buffer
+	require.NoError(t, report.Write(&buffer), "Writing the report should work")
+	require.Contains(t, buffer.String(), `"command": "echo <World>"`, "HTML characters are not escaped")
+	var decoded Report
+	require.NoError(t, json.Unmarshal(buffer.Bytes(), &decoded), "The report should be valid JSON")
+	report.SchemaVersion = SchemaVersion
+	require.Equal(t, report, decoded, "The report should survive a round trip")
+}
+
+func TestStream(t *testing.T) {
+	var buffer bytes.Buffer
+	stream := NewStream(&buffer)
+	var emitters sync.WaitGroup
+	for index := 1; index <= 10; index++ {
+		emitters.Add(1)
+		go func(index int) {
+			defer emitters.Done()
+			interaction := Interaction{Command: fmt.Sprintf("echo %d", index), Status: StatusPending}
+			require.NoError(t, stream.Emit(Event{Type: EventInteractionStarted, Filename: "README.md", Index: index, Interaction: &interaction}))
+		}(index)
+	}
+	emitters.Wait()
+	scanner := bufio.NewScanner(&buffer)
+	count := 0
+	for scanner.Scan() {
+		var event Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event), "Every line should be a JSON event")
+		require.Equal(t, SchemaVersion, event.SchemaVersion, "Every event has the schema version")
+		require.Equal(t, EventInteractionStarted, event.Type)
+		require.Equal(t, fmt.Sprintf("echo %d", event.Index), event.Interaction.Command, "Events should not be mixed up")
+		require.WithinDuration(t, time.Now(), event.Time, time.Minute, "The time of the event is set")
+		count++
+	}
+	require.Equal(t, 10, count, "Every event is written on its own line")
+}
@@ -0,0 +1,104 @@
+package junitxml
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Read parses a JUnit XML document into test suites.
+// The root element of the document is either testsuites, or a single testsuite as written by some tools.
+// Elements and attributes that are not part of the test suite types, like system-out, are ignored.
+func Read(r io.Reader) (JUnitTestSuites, error) {
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return JUnitTestSuites{}, fmt.Errorf("unable to read XML document: no testsuites element found")
+		} else if err != nil {
+			return JUnitTestSuites{}, fmt.Errorf("unable to read XML document: %v", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue // the XML declaration, comments and white space before the root element
+		}
+		switch start.Name.Local {
+		case "testsuites":
+			var testsuites JUnitTestSuites
+			if err := decoder.DecodeElement(&testsuites, &start); err != nil {
+				return JUnitTestSuites{}, fmt.Errorf("unable to read XML document: %v", err)
+			}
+			return testsuites, nil
+		case "testsuite":
+			var suite JUnitTestSuite
+			if err := decoder.DecodeElement(&suite, &start); err != nil {
+				return JUnitTestSuites{}, fmt.Errorf("unable to read XML document: %v", err)
+			}
+			return JUnitTestSuites{Suites: []JUnitTestSuite{suite}}, nil
+		default:
+			return JUnitTestSuites{}, fmt.Errorf("unable to read XML document: unexpected root element %s", start.Name.Local)
+		}
+	}
+}
+
+// Merge combines the test suites of multiple documents, for example of sharded runs, into one.
+// The suites are kept in order. Suites with the same name are combined into the first of them: their test
+// cases are appended, their times are added up, and properties that it does not contain yet are added.
+// The counters of all suites are computed from their test cases.
+func Merge(documents ...JUnitTestSuites) JUnitTestSuites {
+	var result JUnitTestSuites
+	indexes := make(map[string]int) // the position of the suites in the result by their names
+	for _, document := range documents {
+		for _, suite := range document.Suites {
+			index, found := indexes[suite.Name]
+			if !found {
+				indexes[suite.Name] = len(result.Suites)
+				suite.Properties = append([]JUnitProperty{}, suite.Properties...)
+				suite.TestCases = append([]JUnitTestCase{}, suite.TestCases...)
+				result.Suites = append(result.Suites, suite)
+				continue
+			}
+			merged := &result.Suites[index]
+			merged.TestCases = append(merged.TestCases, suite.TestCases...)
+			merged.Time = FormatTime(parseTime(merged.Time) + parseTime(suite.Time))
+			for _, property := range suite.Properties {
+				if !merged.hasProperty(property) {
+					merged.Properties = append(merged.Properties, property)
+				}
+			}
+		}
+	}
+	for index := range result.Suites {
+		suite := &result.Suites[index]
+		suite.Tests = suite.TestCount()
+		suite.Failures = suite.FailureCount()
+		suite.Errors = suite.ErrorCount()
+		suite.Skipped = suite.SkipCount()
+	}
+	return result
+}
+
+// hasProperty returns true if the suite contains the property with the same value
+func (suite *JUnitTestSuite) hasProperty(property JUnitProperty) bool {
+	for _, existing := range suite.Properties {
+		if existing == property {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTime converts a time attribute into a duration, invalid or missing times count as zero
+func parseTime(text string) time.Duration {
+	seconds, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
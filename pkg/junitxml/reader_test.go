@@ -0,0 +1,104 @@
+package junitxml
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// suite returns a test suite with one test case of every kind
+func suite(name string) JUnitTestSuite {
+	ts := JUnitTestSuite{Name: name, Time: "1.500"}
+	ts.AddProperty("shelldoc-version", "1.0")
+	passed := JUnitTestCase{Classname: name, Name: "echo Hello", Time: "0.001"}
+	ts.RegisterTestCase(passed)
+	failed := JUnitTestCase{Classname: name, Name: "echo World", Time: "0.002", SystemErr: "(the error output)"}
+	failed.RegisterFailure("FAILURE", "FAIL (mismatch)", "-Hello\n+World")
+	ts.RegisterTestCase(failed)
+	broken := JUnitTestCase{Classname: name, Name: "sleep 10", Time: "1.000"}
+	broken.RegisterError("ERROR", "ERROR (timeout)", "command did not finish within 1s")
+	ts.RegisterTestCase(broken)
+	skipped := JUnitTestCase{Classname: name, Name: "apt-get install shelldoc", Time: "0.000"}
+	skipped.RegisterSkipped("root privileges required")
+	ts.RegisterTestCase(skipped)
+	return ts
+}
+
+func TestReadWrittenDocument(t *testing.T) {
+	testsuites := JUnitTestSuites{Suites: []JUnitTestSuite{suite("README.md"), suite("INSTALL.md")}}
+	var buffer bytes.Buffer
+	require.NoError(t, testsuites.Write(&buffer), "Unable to write XML document")
+	read, err := Read(&buffer)
+	require.NoError(t, err, "Unable to read the XML document")
+	require.Len(t, read.Suites, 2)
+	for index, ts := range read.Suites {
+		expected := testsuites.Suites[index]
+		require.Equal(t, expected.Name, ts.Name)
+		require.Equal(t, expected.Time, ts.Time)
+		require.Equal(t, expected.Properties, ts.Properties)
+		require.Equal(t, 4, ts.Tests)
+		require.Equal(t, 1, ts.Failures)
+		require.Equal(t, 1, ts.Errors)
+		require.Equal(t, 1, ts.Skipped)
+		require.Len(t, ts.TestCases, 4)
+		for caseIndex, testcase := range ts.TestCases {
+			testcase.XMLName = expected.TestCases[caseIndex].XMLName
+			require.Equal(t, expected.TestCases[caseIndex], testcase, "The test cases are read as they were written")
+		}
+	}
+}
+
+func TestReadSingleTestSuite(t *testing.T) {
+	document := `<?xml version="1.0" encoding="UTF-8"?>
+<!-- written by another tool -->
+<testsuite name="unit" tests="2" failures="1">
+	<testcase classname="pkg" name="TestOne" time="0.1"><system-out>ignored</system-out></testcase>
+	<testcase classname="pkg" name="TestTwo" time="0.2"><failure message="wrong">details</failure></testcase>
+</testsuite>
+`
+	read, err := Read(strings.NewReader(document))
+	require.NoError(t, err, "Documents with a single testsuite element should be accepted")
+	require.Len(t, read.Suites, 1)
+	require.Equal(t, "unit", read.Suites[0].Name)
+	require.Equal(t, 1, read.Suites[0].FailureCount())
+	require.Equal(t, "details", read.Suites[0].TestCases[1].Failure.Contents)
+}
+
+func TestReadInvalidDocuments(t *testing.T) {
+	for _, document := range []string{"", "<html></html>", "<testsuites><testsuite>"} {
+		_, err := Read(strings.NewReader(document))
+		require.Error(t, err, "Reading %q should fail", document)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	first := JUnitTestSuites{Suites: []JUnitTestSuite{suite("README.md"), suite("INSTALL.md")}}
+	other := suite("README.md")
+	other.AddProperty("shelldoc-sandbox", "/tmp/shelldoc-sandbox-1")
+	second := JUnitTestSuites{Suites: []JUnitTestSuite{suite("CONTRIBUTING.md"), other}}
+	merged := Merge(first, second)
+	require.Len(t, merged.Suites, 3, "Suites with the same name are combined")
+	require.Equal(t, []string{"README.md", "INSTALL.md", "CONTRIBUTING.md"},
+		[]string{merged.Suites[0].Name, merged.Suites[1].Name, merged.Suites[2].Name}, "The order of the suites is kept")
+	combined := merged.Suites[0]
+	require.Equal(t, 8, combined.Tests, "The test cases are appended")
+	require.Equal(t, 2, combined.Failures)
+	require.Equal(t, 2, combined.Errors)
+	require.Equal(t, 2, combined.Skipped)
+	require.Equal(t, "3.000", combined.Time, "The times are added up")
+	require.Equal(t, []JUnitProperty{{"shelldoc-version", "1.0"}, {"shelldoc-sandbox", "/tmp/shelldoc-sandbox-1"}},
+		combined.Properties, "Properties are not duplicated")
+	require.Len(t, first.Suites[0].TestCases, 4, "The input documents are not modified")
+
+	file, err := openTmpFile()
+	require.NoError(t, err, "Unable to open file for temporary XML document")
+	defer removeTmpFile(file.Name())
+	require.NoError(t, merged.Write(file), "Unable to write temporary XML document")
+	require.NoError(t, validateXMLFile(file.Name()), "The merged document fails to validate")
+}
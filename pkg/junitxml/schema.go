@@ -13,8 +13,8 @@ import (
 
 // JUnitTestSuites is a collection of JUnit test suites.
 type JUnitTestSuites struct {
-	XMLName xml.Name `xml:"testsuites"`
-	Suites  []JUnitTestSuite
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []JUnitTestSuite `xml:"testsuite"`
 }
 
 // JUnitTestSuite is a single JUnit test suite which may contain many
@@ -24,10 +24,11 @@ type JUnitTestSuite struct {
 	Tests      int             `xml:"tests,attr"`
 	Failures   int             `xml:"failures,attr"`
 	Errors     int             `xml:"errors,attr"`
+	Skipped    int             `xml:"skipped,attr,omitempty"`
 	Time       string          `xml:"time,attr"`
 	Name       string          `xml:"name,attr"`
 	Properties []JUnitProperty `xml:"properties>property,omitempty"`
-	TestCases  []JUnitTestCase
+	TestCases  []JUnitTestCase `xml:"testcase"`
 }
 
 // JUnitTestCase is a single test case with its result.
@@ -39,11 +40,13 @@ type JUnitTestCase struct {
 	SkipMessage *JUnitSkipMessage `xml:"skipped,omitempty"`
 	Failure     *JUnitFailure     `xml:"failure,omitempty"`
 	Error       *JUnitError       `xml:"error,omitempty"`
+	SystemErr   string            `xml:"system-err,omitempty"`
 }
 
 // JUnitSkipMessage contains the reason why a testcase was skipped.
+// The bundled schema defines the skipped element as text only, so the message is stored as its content.
 type JUnitSkipMessage struct {
-	Message string `xml:"message,attr"`
+	Message string `xml:",chardata"`
 }
 
 // JUnitProperty represents a key/value pair used to define properties.
@@ -107,11 +110,16 @@ func (testcase *JUnitTestCase) RegisterError(errortype string, message string, c
 	testcase.Error = junitError
 }
 
+// RegisterSkipped registers that a test case was not executed.
+func (testcase *JUnitTestCase) RegisterSkipped(message string) {
+	testcase.SkipMessage = &JUnitSkipMessage{Message: message}
+}
+
 // SuccessCount returns the number of successfully executed test cases in the test suite.
 func (suite *JUnitTestSuite) SuccessCount() int {
 	counter := 0
 	for _, testcase := range suite.TestCases {
-		if testcase.Failure == nil && testcase.Error == nil {
+		if testcase.Failure == nil && testcase.Error == nil && testcase.SkipMessage == nil {
 			counter++
 		}
 	}
@@ -129,6 +137,17 @@ func (suite *JUnitTestSuite) FailureCount() int {
 	return counter
 }
 
+// SkipCount returns the number of test cases that have been skipped.
+func (suite *JUnitTestSuite) SkipCount() int {
+	counter := 0
+	for _, testcase := range suite.TestCases {
+		if testcase.SkipMessage != nil {
+			counter++
+		}
+	}
+	return counter
+}
+
 // ErrorCount returns the number of test cases with execution errors.
 func (suite *JUnitTestSuite) ErrorCount() int {
 	counter := 0
@@ -151,6 +170,8 @@ func (suite *JUnitTestSuite) RegisterTestCase(testcase JUnitTestCase) {
 		suite.Failures++
 	} else if testcase.Error != nil {
 		suite.Errors++
+	} else if testcase.SkipMessage != nil {
+		suite.Skipped++
 	}
 }
 
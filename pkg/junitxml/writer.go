@@ -4,15 +4,72 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
+	"strings"
+	"unicode/utf8"
 )
 
 func (testsuites JUnitTestSuites) Write(w io.Writer) error {
 	io.WriteString(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
 	enc := xml.NewEncoder(w)
 	enc.Indent("", "\t")
-	if err := enc.Encode(testsuites); err != nil {
-		fmt.Printf("unable to write XML document: %v", err)
+	if err := enc.Encode(testsuites.sanitized()); err != nil {
+		return fmt.Errorf("unable to write XML document: %v", err)
 	}
 	io.WriteString(w, "\n")
 	return nil
 }
+
+// Sanitize escapes the characters in text that cannot be represented in XML documents
+// Invalid UTF-8 bytes and control characters other than tab, newline and carriage return are replaced by
+// escape sequences like \x00, backslashes are kept as they are.
+func Sanitize(text string) string {
+	var builder strings.Builder
+	for index := 0; index < len(text); {
+		r, width := utf8.DecodeRuneInString(text[index:])
+		switch {
+		case r == utf8.RuneError && width == 1:
+			fmt.Fprintf(&builder, "\\x%02x", text[index])
+		case r < 0x20 && r != '\t' && r != '\n' && r != '\r', r == 0xFFFE, r == 0xFFFF:
+			fmt.Fprintf(&builder, "\\x%02x", r)
+		default:
+			builder.WriteRune(r)
+		}
+		index += width
+	}
+	return builder.String()
+}
+
+// sanitized returns a copy of the test suites with all texts passed through Sanitize
+func (testsuites JUnitTestSuites) sanitized() JUnitTestSuites {
+	result := testsuites
+	result.Suites = make([]JUnitTestSuite, len(testsuites.Suites))
+	for index, suite := range testsuites.Suites {
+		suite.Name = Sanitize(suite.Name)
+		properties := make([]JUnitProperty, len(suite.Properties))
+		for index, property := range suite.Properties {
+			properties[index] = JUnitProperty{Name: Sanitize(property.Name), Value: Sanitize(property.Value)}
+		}
+		suite.Properties = properties
+		testcases := make([]JUnitTestCase, len(suite.TestCases))
+		for index, testcase := range suite.TestCases {
+			testcase.Classname = Sanitize(testcase.Classname)
+			testcase.Name = Sanitize(testcase.Name)
+			testcase.SystemErr = Sanitize(testcase.SystemErr)
+			if testcase.SkipMessage != nil {
+				testcase.SkipMessage = &JUnitSkipMessage{Message: Sanitize(testcase.SkipMessage.Message)}
+			}
+			if testcase.Failure != nil {
+				testcase.Failure = &JUnitFailure{Message: Sanitize(testcase.Failure.Message), Type: Sanitize(testcase.Failure.Type),
+					Contents: Sanitize(testcase.Failure.Contents)}
+			}
+			if testcase.Error != nil {
+				testcase.Error = &JUnitError{Message: Sanitize(testcase.Error.Message), Type: Sanitize(testcase.Error.Type),
+					Contents: Sanitize(testcase.Error.Contents)}
+			}
+			testcases[index] = testcase
+		}
+		suite.TestCases = testcases
+		result.Suites[index] = suite
+	}
+	return result
+}
@@ -75,6 +75,7 @@ func TestOneTestSuite(t *testing.T) {
 			Type:     "mismatch",
 			Contents: "(the test output)",
 		},
+		SystemErr: "(the error output)",
 	}
 	ts.TestCases = append(ts.TestCases, testCase)
 	testsuites.Suites = append(testsuites.Suites, ts)
@@ -89,3 +90,59 @@ func TestOneTestSuite(t *testing.T) {
 	// Verify it is schema compliant.
 	require.NoError(t, validateXMLFile(file.Name()), "XML document fails to validate")
 }
+
+func TestSkippedTestCase(t *testing.T) {
+	// Write a test suite with a skipped test case.
+	ts := JUnitTestSuite{Name: "Test-Skipped"}
+	ts.AddProperty("go.version", runtime.Version())
+	testCase := JUnitTestCase{
+		Classname: "README.md",
+		Name:      "apt-get install shelldoc",
+	}
+	testCase.RegisterSkipped("root privileges required")
+	ts.RegisterTestCase(testCase)
+	require.Equal(t, 1, ts.SkipCount(), "The test case was skipped")
+	require.Equal(t, 0, ts.SuccessCount(), "Skipped test cases are not successful")
+	testsuites := JUnitTestSuites{Suites: []JUnitTestSuite{ts}}
+
+	file, err := openTmpFile()
+	require.NoError(t, err, "Unable to open file for temporary XML document")
+	defer removeTmpFile(file.Name())
+
+	err = testsuites.Write(file)
+	require.NoError(t, err, "Unable to write temporary XML document")
+	// Verify it is schema compliant.
+	require.NoError(t, validateXMLFile(file.Name()), "XML document fails to validate")
+}
+
+func TestBinaryOutput(t *testing.T) {
+	// Write a test suite with output that cannot be represented in XML.
+	ts := JUnitTestSuite{Name: "Test-Binary"}
+	ts.AddProperty("go.version", runtime.Version())
+	testCase := JUnitTestCase{
+		Classname: "README.md",
+		Name:      "printf 'a\\000b\\377'",
+		SystemErr: "a\x00b\xff",
+	}
+	testCase.RegisterFailure("FAILURE", "FAIL (mismatch)", "output: \"a\x00b\xff\x1b[0m\"")
+	ts.RegisterTestCase(testCase)
+	testsuites := JUnitTestSuites{Suites: []JUnitTestSuite{ts}}
+
+	file, err := openTmpFile()
+	require.NoError(t, err, "Unable to open file for temporary XML document")
+	defer removeTmpFile(file.Name())
+
+	err = testsuites.Write(file)
+	require.NoError(t, err, "Unable to write temporary XML document")
+	// Verify it is schema compliant.
+	require.NoError(t, validateXMLFile(file.Name()), "XML document fails to validate")
+	content, err := ioutil.ReadFile(file.Name())
+	require.NoError(t, err, "Unable to read temporary XML document")
+	require.Contains(t, string(content), `a\x00b\xff\x1b[0m`, "The binary output is escaped")
+	require.Equal(t, "a\x00b\xff", testsuites.Suites[0].TestCases[0].SystemErr, "The test suites are not modified")
+}
+
+func TestSanitize(t *testing.T) {
+	require.Equal(t, "Hello\tWorld\n", Sanitize("Hello\tWorld\n"), "Printable text is not modified")
+	require.Equal(t, "Grüße \\x00\\x07\\xfe", Sanitize("Grüße \x00\x07\xfe"), "Control characters and invalid UTF-8 are escaped")
+}
@@ -0,0 +1,87 @@
+package run
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/endocode/shelldoc/pkg/ci"
+	"github.com/endocode/shelldoc/pkg/jsonreport"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// WriteCodeQuality writes the failed interactions to the specified GitLab Code Quality report file
+func (context *Context) WriteCodeQuality() error {
+	if len(context.CodeQualityFile) > 0 {
+		file, err := os.OpenFile(context.CodeQualityFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+		if err != nil {
+			return fmt.Errorf("unable to open Code Quality report file for writing: %v", err)
+		}
+		defer file.Close()
+		var issues []ci.Issue
+		for _, result := range context.Results {
+			for _, interaction := range result.Interactions {
+				if annotation, ok := annotation(interaction); ok {
+					issues = append(issues, codeQualityIssue(annotation, interaction))
+				}
+			}
+		}
+		if err := ci.WriteCodeQuality(file, issues); err != nil {
+			return fmt.Errorf("error writing Code Quality report file: %v", err)
+		}
+	}
+	return nil
+}
+
+// annotation returns an annotation for an interaction that failed or could not be executed
+// It returns false for all other interactions.
+func annotation(interaction *tokenizer.Interaction) (ci.Annotation, bool) {
+	switch status(interaction) {
+	case jsonreport.StatusFail, jsonreport.StatusError:
+	default:
+		return ci.Annotation{}, false
+	}
+	message := []string{interaction.Result()}
+	if len(interaction.Comment) > 0 {
+		message[0] = interaction.Comment
+	}
+	message = append(message, interaction.Diff()...)
+	annotation := ci.Annotation{
+		Level:   ci.LevelError,
+		File:    interaction.Filename,
+		Line:    interaction.CommandSpan.FirstLine,
+		EndLine: interaction.CommandSpan.LastLine,
+		Title:   fmt.Sprintf("%s: %s", name(interaction), interaction.Result()),
+		Message: strings.Join(message, "\n"),
+	}
+	if interaction.ResponseSpan.Known() && !interaction.ResponseSpan.Empty() {
+		annotation.EndLine = interaction.ResponseSpan.LastLine
+	}
+	return annotation, true
+}
+
+// codeQualityIssue converts the annotation of an interaction into a Code Quality issue
+// Errors of shelldoc are more severe than failed commands. Code Quality descriptions are a single line, so the
+// diff is not included.
+func codeQualityIssue(annotation ci.Annotation, interaction *tokenizer.Interaction) ci.Issue {
+	issue := ci.Issue{
+		Description: annotation.Title,
+		CheckName:   "shelldoc-" + status(interaction),
+		Severity:    ci.SeverityMajor,
+		Location:    ci.Location{Path: annotation.File, Lines: ci.Lines{Begin: annotation.Line}},
+	}
+	if len(interaction.Comment) > 0 {
+		issue.Description = fmt.Sprintf("%s (%s)", annotation.Title, interaction.Comment)
+	}
+	if status(interaction) == jsonreport.StatusError {
+		issue.Severity = ci.SeverityCritical
+	}
+	if issue.Location.Lines.Begin < 1 {
+		issue.Location.Lines.Begin = 1 // GitLab requires a line
+	}
+	return issue
+}
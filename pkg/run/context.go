@@ -1,37 +1,137 @@
 package run
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"sync"
+	"time"
 
+	"github.com/endocode/shelldoc/pkg/jsonreport"
 	"github.com/endocode/shelldoc/pkg/junitxml"
 )
 
 // Context contains the context of an execution of the run subcommand.
 type Context struct {
 	// input (configuration) variables
-	ShellName     string
-	Verbose       bool
-	FailureStops  bool
-	XMLOutputFile string
-	ReplaceDots   bool
-	Files         []string
+	ShellName        string
+	Verbose          bool
+	FailureStops     bool
+	XMLOutputFile    string
+	TAPOutputFile    string
+	JSONOutputFile   string
+	HTMLOutputFile   string
+	CodeQualityFile  string
+	Format           string
+	JSONStream       bool
+	ReplaceDots      bool
+	Timeout          time.Duration
+	Update           bool
+	Color            string
+	Prompts          []string
+	RootPrompts      []string
+	RootMode         string
+	Jobs             int
+	Sandbox          bool
+	Fixtures         string
+	KeepSandbox      bool
+	CleanEnv         bool
+	PassEnv          []string
+	Env              []string
+	EnvFile          string
+	Container        string
+	ContainerRuntime string
+	SSH              string
+	SSHOptions       []string
+	PTY              bool
+	StripANSI        bool
+	RestartShell     bool
+	Files            []string
 	// output variables
 	Suites     junitxml.JUnitTestSuites
+	Results    []FileResult
 	returnCode int
+	mutex      sync.Mutex         // protects returnCode when files are executed concurrently
+	events     *jsonreport.Stream // the JSON event stream, if enabled
 }
 
 // RegisterReturnCode registers a potential error. The return code can never decrease.
+// It is safe to call RegisterReturnCode concurrently.
 func (context *Context) RegisterReturnCode(returnCode int) int {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
 	context.returnCode = max(context.returnCode, returnCode)
 	return context.returnCode
 }
 
 // ReturnCode returns the overall result of the operation.
 func (context *Context) ReturnCode() int {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
 	return context.returnCode
 }
 
+// Values for the Color option
+const (
+	// ColorAuto enables colored output if the output is written to a terminal
+	ColorAuto = "auto"
+	// ColorAlways enables colored output
+	ColorAlways = "always"
+	// ColorNever disables colored output
+	ColorNever = "never"
+)
+
+// Values for the Format option, which controls the console output
+const (
+	// FormatText produces human-readable output
+	FormatText = "text"
+	// FormatGitHub adds GitHub Actions workflow commands for failed commands to the human-readable output
+	FormatGitHub = "github"
+)
+
+// Values for the RootMode option, which controls how commands marked with a root prompt are executed
+const (
+	// RootModeRun executes commands that require root privileges like all other commands
+	RootModeRun = "run"
+	// RootModeSkip skips commands that require root privileges
+	RootModeSkip = "skip"
+	// RootModeSudo executes commands that require root privileges using sudo
+	RootModeSudo = "sudo"
+)
+
+// Validate checks the options that do not depend on the input files, before any file is executed
+func (context *Context) Validate() error {
+	switch context.RootMode {
+	case "", RootModeRun, RootModeSkip, RootModeSudo:
+	default:
+		return fmt.Errorf("unknown mode for commands that require root privileges: %s", context.RootMode)
+	}
+	switch context.Format {
+	case "", FormatText, FormatGitHub:
+	default:
+		return fmt.Errorf("unknown output format: %s", context.Format)
+	}
+	if _, err := context.prompts(); err != nil {
+		return fmt.Errorf("invalid prompt: %v", err)
+	}
+	return nil
+}
+
+// useColor returns true if the console output should be colored
+func (context *Context) useColor() bool {
+	switch context.Color {
+	case ColorAlways:
+		return true
+	case ColorAuto:
+		info, err := os.Stdout.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0
+	default:
+		return false
+	}
+}
+
 // WriteXML writes the test results to the specified XML output file
 func (context *Context) WriteXML() error {
 	if len(context.XMLOutputFile) > 0 {
@@ -47,19 +147,77 @@ func (context *Context) WriteXML() error {
 }
 
 // ExecuteFiles runs each file through performInteractions and aggregates the results
+// Up to Jobs files are executed concurrently, each in its own shell. The console output of every file is
+// printed when the file is finished, and the test suites are stored in the order of the files.
+// If JSONStream is set, JSON events are written to stdout instead of the console output, and errors are written to stderr.
 func (context *Context) ExecuteFiles() int {
 	context.RegisterReturnCode(returnSuccess)
-	for _, file := range context.Files {
-		suite, err := context.performInteractions(file)
-		if err != nil {
-			fmt.Println(err) // log may be disabled (see "verbose")
-			os.Exit(returnError)
+	var console io.Writer = os.Stdout // receives the error messages
+	if context.JSONStream {
+		context.events = jsonreport.NewStream(os.Stdout)
+		console = os.Stderr
+	}
+	if err := context.Validate(); err != nil {
+		fmt.Fprintln(console, err)
+		return context.RegisterReturnCode(returnError)
+	}
+	jobs := context.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	results := make([]*FileResult, len(context.Files))
+	indexes := make(chan int)
+	var outputMutex sync.Mutex // serializes writing the console output of the files
+	var failed error           // the first error, protected by outputMutex
+	var workers sync.WaitGroup
+	for worker := 0; worker < jobs; worker++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for index := range indexes {
+				var buffer bytes.Buffer
+				var out io.Writer = &buffer
+				if context.JSONStream {
+					out = ioutil.Discard // stdout is used for the events
+				} else if jobs == 1 {
+					out = os.Stdout // with only one job, the output does not need to be buffered
+				}
+				result, err := context.performInteractionsTo(context.Files[index], out)
+				outputMutex.Lock()
+				os.Stdout.Write(buffer.Bytes())
+				if err != nil && failed == nil {
+					fmt.Fprintln(console, err) // log may be disabled (see "verbose")
+					failed = err
+				}
+				outputMutex.Unlock()
+				results[index] = result
+			}
+		}()
+	}
+	for index := range context.Files {
+		outputMutex.Lock()
+		stop := failed != nil
+		outputMutex.Unlock()
+		if stop {
+			break
 		}
-		context.Suites.Suites = append(context.Suites.Suites, *suite)
+		indexes <- index
 	}
-	if err := context.WriteXML(); err != nil {
-		fmt.Printf("%v\n", err)
+	close(indexes)
+	workers.Wait()
+	if failed != nil {
 		os.Exit(returnError)
 	}
+	for _, result := range results {
+		context.Results = append(context.Results, *result)
+		context.Suites.Suites = append(context.Suites.Suites, *result.Suite)
+	}
+	for _, write := range []func() error{context.WriteXML, context.WriteTAP, context.WriteJSON, context.WriteHTML,
+		context.WriteCodeQuality} {
+		if err := write(); err != nil {
+			fmt.Fprintf(console, "%v\n", err)
+			os.Exit(returnError)
+		}
+	}
 	return context.ReturnCode()
 }
@@ -0,0 +1,114 @@
+package run
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultEnvironment contains the variables that are set in a clean environment, unless they are overridden
+var DefaultEnvironment = []string{"LC_ALL=C", "TERM=dumb"}
+
+// DefaultPassEnv contains the variables that are passed through into a clean environment by default
+var DefaultPassEnv = []string{"HOME", "PATH", "TMPDIR", "USER"}
+
+// environment returns the environment for the shell, and the variables that are recorded in the test report
+// If nil is returned as the environment, the shell inherits the environment of shelldoc. In a clean environment,
+// all variables are recorded, otherwise only the ones explicitly set using Env or EnvFile are.
+// Shells in containers or on remote hosts never get variables from the environment of shelldoc, only the ones set
+// using Env or EnvFile, and DefaultEnvironment in a clean environment.
+func (context *Context) environment(remote bool) ([]string, []string, error) {
+	var variables []string
+	if len(context.EnvFile) > 0 {
+		fromFile, err := readEnvFile(context.EnvFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		variables = append(variables, fromFile...)
+	}
+	for _, variable := range context.Env {
+		if !strings.Contains(variable, "=") {
+			return nil, nil, fmt.Errorf("environment variables need to be specified as KEY=VALUE: %s", variable)
+		}
+		variables = append(variables, variable)
+	}
+	if remote {
+		base := []string{}
+		if context.CleanEnv {
+			base = DefaultEnvironment
+		}
+		env := mergeEnvironment(base, variables)
+		return env, env, nil
+	}
+	if !context.CleanEnv {
+		if len(variables) == 0 {
+			return nil, nil, nil
+		}
+		return mergeEnvironment(os.Environ(), variables), mergeEnvironment(nil, variables), nil
+	}
+	passEnv := context.PassEnv
+	if passEnv == nil {
+		passEnv = DefaultPassEnv
+	}
+	var passed []string
+	for _, name := range passEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			passed = append(passed, name+"="+value)
+		}
+	}
+	env := mergeEnvironment(mergeEnvironment(DefaultEnvironment, passed), variables)
+	return env, env, nil
+}
+
+// mergeEnvironment returns the variables of base, overridden or extended by the variables in overrides
+func mergeEnvironment(base []string, overrides []string) []string {
+	var result []string
+	positions := make(map[string]int)
+	for _, variable := range append(append([]string{}, base...), overrides...) {
+		name := strings.SplitN(variable, "=", 2)[0]
+		if position, ok := positions[name]; ok {
+			result[position] = variable
+		} else {
+			positions[name] = len(result)
+			result = append(result, variable)
+		}
+	}
+	return result
+}
+
+// readEnvFile reads the KEY=VALUE lines of an environment file
+// Empty lines and comments starting with # are ignored, quotes around the values are removed.
+func readEnvFile(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read environment file: %v", err)
+	}
+	defer file.Close()
+	var variables []string
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		elements := strings.SplitN(line, "=", 2)
+		if len(elements) != 2 || len(strings.TrimSpace(elements[0])) == 0 {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE: %s", filename, number, line)
+		}
+		name, value := strings.TrimSpace(elements[0]), strings.TrimSpace(elements[1])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		variables = append(variables, name+"="+value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read environment file: %v", err)
+	}
+	return variables, nil
+}
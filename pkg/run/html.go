@@ -0,0 +1,78 @@
+package run
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/endocode/shelldoc/pkg/htmlreport"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+	"github.com/endocode/shelldoc/pkg/version"
+)
+
+// WriteHTML writes the test results to the specified HTML output file
+func (context *Context) WriteHTML() error {
+	if len(context.HTMLOutputFile) > 0 {
+		file, err := os.OpenFile(context.HTMLOutputFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+		if err != nil {
+			return fmt.Errorf("unable to open HTML output file for writing: %v", err)
+		}
+		defer file.Close()
+		if err := htmlReport(context.Results, context.ReturnCode()).Write(file); err != nil {
+			return fmt.Errorf("error writing HTML output file: %v", err)
+		}
+	}
+	return nil
+}
+
+// htmlReport converts the results into an HTML report that shows the results next to the code blocks
+func htmlReport(results []FileResult, returnCode int) htmlreport.Report {
+	report := htmlreport.Report{Title: "shelldoc results", Version: version.Version(), Result: result(returnCode)}
+	for _, fileResult := range results {
+		suite := fileResult.Suite
+		file := htmlreport.File{
+			Filename:  fileResult.Filename,
+			Result:    fileResult.Result,
+			Tests:     suite.TestCount(),
+			Successes: suite.SuccessCount(),
+			Failures:  suite.FailureCount(),
+			Errors:    suite.ErrorCount(),
+			Skipped:   suite.SkipCount(),
+			Duration:  fileResult.Duration,
+			Source:    fileResult.Source,
+		}
+		for _, interaction := range fileResult.Interactions {
+			file.Interactions = append(file.Interactions, htmlInteraction(interaction))
+		}
+		report.Files = append(report.Files, file)
+	}
+	return report
+}
+
+// htmlInteraction converts an executed interaction
+func htmlInteraction(interaction *tokenizer.Interaction) htmlreport.Interaction {
+	converted := htmlreport.Interaction{
+		Caption:     interaction.Caption,
+		Command:     interaction.Cmd,
+		Expected:    interaction.Response,
+		Output:      interaction.Output,
+		ErrorOutput: interaction.ErrorOutput,
+		ExitCode:    interaction.ExitCode,
+		Result:      interaction.Result(),
+		Status:      status(interaction), // the values of the JSON report are the CSS classes of the badges
+		Comment:     interaction.Comment,
+		Duration:    interaction.Duration,
+		Line:        interaction.CommandSpan.FirstLine,
+		EndLine:     interaction.CommandSpan.LastLine,
+	}
+	if interaction.ResponseSpan.Known() && !interaction.ResponseSpan.Empty() {
+		converted.EndLine = interaction.ResponseSpan.LastLine
+	}
+	if interaction.HasFailure() {
+		converted.Diff = interaction.Diff()
+	}
+	return converted
+}
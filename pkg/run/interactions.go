@@ -2,11 +2,17 @@ package run
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/endocode/shelldoc/pkg/diff"
+	"github.com/endocode/shelldoc/pkg/jsonreport"
 	"github.com/endocode/shelldoc/pkg/junitxml"
 	"github.com/endocode/shelldoc/pkg/shell"
 	"github.com/endocode/shelldoc/pkg/tokenizer"
@@ -37,22 +43,73 @@ func result(code int) string {
 	}
 }
 
+// FileResult contains the results of executing the interactions of one file
+type FileResult struct {
+	// Filename is the path of the Markdown file
+	Filename string
+	// Suite contains the results as a JUnit test suite
+	Suite *junitxml.JUnitTestSuite
+	// Interactions contains the executed interactions, including setup and teardown scripts, in the order of execution
+	Interactions []*tokenizer.Interaction
+	// Result is the result of the file (SUCCESS, FAILURE or ERROR)
+	Result string
+	// Duration is the time it took to execute the file
+	Duration time.Duration
+	// Source is the Markdown source of the file, before it was updated
+	Source []byte
+}
+
 func (context *Context) performInteractions(inputfile string) (*junitxml.JUnitTestSuite, error) {
+	result, err := context.performInteractionsTo(inputfile, os.Stdout)
+	if err != nil {
+		return nil, err
+	}
+	return result.Suite, nil
+}
+
+// performInteractionsTo executes the interactions in the input file and writes the console output to out
+func (context *Context) performInteractionsTo(inputfile string, out io.Writer) (*FileResult, error) {
 	// the test suite object for this file
 	suite := &junitxml.JUnitTestSuite{Name: inputfile}
 	suite.AddProperty("shelldoc-version", version.Version())
+	started := time.Now()
 	defer junitxml.RegisterElapsedTime(time.Now(), &suite.Time)
 	// detect shell
-	shellpath, err := shell.DetectShell(context.ShellName)
+	sh, err := context.executor(inputfile)
 	if err != nil {
 		return nil, err
 	}
-	// start a background shell, it will run until the function ends
-	shell, err := shell.StartShell(shellpath)
+	// create the sandbox directory the shell is executed in, it will be removed when the function ends
+	var sandbox string
+	if context.sandboxed() {
+		if sandbox, err = createSandbox(context.Fixtures); err != nil {
+			return nil, err
+		}
+		if !context.KeepSandbox {
+			defer os.RemoveAll(sandbox)
+		}
+		suite.AddProperty("shelldoc-sandbox", sandbox)
+	}
+	// set up the environment of the shell and record it in the report
+	// the environment of shelldoc is not passed into containers or to remote hosts
+	env, recorded, err := context.environment(len(context.Container) > 0 || len(context.SSH) > 0)
 	if err != nil {
+		return nil, err
+	}
+	for _, variable := range recorded {
+		elements := strings.SplitN(variable, "=", 2)
+		suite.AddProperty("env."+elements[0], elements[1])
+	}
+	if len(context.Container) > 0 {
+		suite.AddProperty("shelldoc-container", context.Container)
+	} else if len(context.SSH) > 0 {
+		suite.AddProperty("shelldoc-ssh", context.SSH)
+	}
+	// start a background shell, it will run until the function ends
+	if err := sh.Start(sandbox, env); err != nil {
 		return nil, fmt.Errorf("unable to start shell: %v", err)
 	}
-	defer shell.Exit()
+	defer func() { sh.Exit() }() // sh may be restarted after a timeout
 	// read input data
 	data, err := ReadInput([]string{inputfile})
 	if err != nil {
@@ -60,11 +117,21 @@ func (context *Context) performInteractions(inputfile string) (*junitxml.JUnitTe
 	}
 	// run the input through the tokenizer
 	visitor := tokenizer.NewInteractionVisitor()
-	tokenizer.Tokenize(data, visitor)
+	visitor.Filename = inputfile
+	if visitor.Prompts, err = context.prompts(); err != nil {
+		return nil, err
+	}
+	if err := tokenizer.Tokenize(data, visitor); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %v", inputfile, err)
+	}
 	// execute the interactions and verify the results:
-	fmt.Printf("SHELLDOC: doc-testing \"%s\" ...\n", inputfile)
+	context.emit(jsonreport.Event{Type: jsonreport.EventFileStarted, Filename: inputfile})
+	fmt.Fprintf(out, "SHELLDOC: doc-testing \"%s\" ...\n", inputfile)
+	// setup directives are executed before, teardown directives after the visible interactions
+	interactions := append(append([]*tokenizer.Interaction{}, visitor.Setup...), visitor.Interactions...)
+	interactions = append(interactions, visitor.Teardown...)
 	// construct the opener and closer format strings, since they depend on verbose mode
-	magnitude := int(math.Log10(float64(len(visitor.Interactions)))) + 1
+	magnitude := int(math.Log10(float64(len(interactions)))) + 1
 	openerLineEnding := "  : "
 	resultString := " "
 	if context.Verbose {
@@ -75,12 +142,82 @@ func (context *Context) performInteractions(inputfile string) (*junitxml.JUnitTe
 	opener := fmt.Sprintf(" CMD %s: %%s%s", counterFormat, openerLineEnding)
 	closer := fmt.Sprintf("%s%%s\n", resultString)
 
-	for index, interaction := range visitor.Interactions {
-		fmt.Printf(opener, fmt.Sprintf("(%d)", index+1), interaction.Describe())
+	counter := 0
+	var terminal shell.Executor // started when the first code block requires a terminal
+	defer func() {
+		if terminal != nil {
+			terminal.Exit()
+		}
+	}()
+	returnCode := returnSuccess // the result of this file, context holds the result of all files
+	register := func(code int) {
+		returnCode = max(returnCode, code)
+		context.RegisterReturnCode(code)
+	}
+	exited := make(map[shell.Executor]bool) // shells that exited and were not restarted
+	// replaySetup executes the setup scripts that succeeded so far in a new shell, which is restarted or on a terminal
+	replaySetup := func(executor shell.Executor) error {
+		for _, setup := range visitor.Setup {
+			if setup.ResultCode != tokenizer.ResultMatch {
+				continue
+			}
+			replay := *setup
+			if err := replay.Execute(executor, context.Timeout); err != nil || replay.HasFailure() {
+				return fmt.Errorf("unable to replay %s at %s in a new shell: %s", setup.Caption, setup.Location(), replay.Result())
+			}
+		}
+		return nil
+	}
+	// restart replaces a shell that timed out or exited, the setup scripts executed so far are executed again
+	restart := func(executor shell.Executor) error {
+		executor.Kill()
+		if err := executor.Start(sandbox, env); err != nil {
+			exited[executor] = true // the following commands, including teardown scripts, are skipped
+			return fmt.Errorf("unable to restart shell: %v", err)
+		}
+		return replaySetup(executor)
+	}
+	var executed []*tokenizer.Interaction // the interactions in the order they were executed
+	perform := func(interaction *tokenizer.Interaction) error {
+		counter++
+		fmt.Fprintf(out, opener, fmt.Sprintf("(%d)", counter), interaction.Describe())
 		if context.Verbose {
-			fmt.Printf(" --> %s\n", interaction.Cmd)
+			fmt.Fprintf(out, " --> %s\n", interaction.Cmd)
+		}
+		if interaction.RootRequired {
+			switch context.RootMode {
+			case RootModeSkip:
+				interaction.Skip("root privileges required")
+			case RootModeSudo:
+				interaction.UseSudo = true
+			}
+		}
+		pending := jsonInteraction(interaction)
+		context.emit(jsonreport.Event{Type: jsonreport.EventInteractionStarted, Filename: inputfile, Index: counter,
+			Interaction: &pending})
+		executor := sh
+		if _, ok := interaction.Attributes[tokenizer.TerminalOption]; ok && !context.PTY {
+			// the code block requires a terminal, its commands are executed in a separate shell on a terminal
+			if terminal == nil {
+				started, err := context.terminalExecutor(inputfile)
+				if err != nil {
+					return err
+				}
+				if err := started.Start(sandbox, env); err != nil {
+					return fmt.Errorf("unable to start shell on a terminal: %v", err)
+				}
+				terminal = started
+				if err := replaySetup(terminal); err != nil {
+					return err
+				}
+			}
+			executor = terminal
 		}
-		testcase, err := context.performTestCase(interaction, shell)
+		if exited[executor] {
+			interaction.Skip("the shell exited in an earlier command")
+		}
+		testcase, err := context.performTestCase(interaction, executor)
+		testcase.SystemErr = strings.Join(interaction.ErrorOutput, "\n")
 		testcase.Classname = inputfile // testcase is always returned, even if err is not nil
 		if context.ReplaceDots {
 			testcase.Classname = strings.ReplaceAll(inputfile, ".", "●")
@@ -88,30 +225,209 @@ func (context *Context) performInteractions(inputfile string) (*junitxml.JUnitTe
 			testcase.Classname = inputfile // testcase is always returned, even if err is not nil
 		}
 		if err != nil {
-			fmt.Printf(" --  ERROR: %v", err)
-			context.RegisterReturnCode(returnError)
-			testcase.RegisterError(result(returnError), interaction.Result(), err.Error())
+			fmt.Fprintf(out, " --  ERROR: %s: %v", interaction.Location(), err)
+			register(returnError)
+			testcase.RegisterError(result(returnError), interaction.Result(), fmt.Sprintf("%s: %v", interaction.Location(), err))
+		}
+		fmt.Fprintf(out, closer, interaction.Result())
+		switch {
+		case interaction.ResultCode == tokenizer.ResultTimeout:
+			// the shell is still busy with the command that timed out, restart it
+			log.Printf("Restarting the shell after a timeout.")
+			if err := restart(executor); err != nil {
+				return err
+			}
+		case interaction.ResultCode == tokenizer.ResultShellExited && context.RestartShell:
+			log.Printf("Restarting the shell after it exited.")
+			if err := restart(executor); err != nil {
+				return err
+			}
+		case interaction.ResultCode == tokenizer.ResultShellExited:
+			exited[executor] = true
 		}
-		fmt.Printf(closer, interaction.Result())
 		if interaction.HasFailure() {
-			context.RegisterReturnCode(returnFailure)
+			if len(interaction.Comment) > 0 {
+				fmt.Fprintf(out, " --  %s: %s\n", interaction.Location(), interaction.Comment)
+			} else {
+				fmt.Fprintf(out, " --  %s\n", interaction.Location())
+			}
+			unified := interaction.Diff()
+			if context.useColor() {
+				unified = diff.Colorize(unified)
+			}
+			for _, line := range unified {
+				fmt.Fprintf(out, "     %s\n", line)
+			}
+			register(returnFailure)
 			testcase.RegisterFailure(result(returnFailure), interaction.Result(), interaction.DescribeFull())
 		}
+		if context.Format == FormatGitHub {
+			if annotation, ok := annotation(interaction); ok {
+				fmt.Fprintln(out, annotation.GitHub())
+			}
+		}
 		suite.RegisterTestCase(*testcase)
+		executed = append(executed, interaction)
+		finished := jsonInteraction(interaction)
+		context.emit(jsonreport.Event{Type: jsonreport.EventInteractionFinished, Filename: inputfile, Index: counter,
+			Interaction: &finished})
+		return nil
+	}
+
+	selectInteractions(visitor.Interactions)
+	var failed error // an error that aborts the execution of the file
+	for _, interaction := range interactions[:len(interactions)-len(visitor.Teardown)] {
+		if failed = perform(interaction); failed != nil {
+			fmt.Fprintln(out) // finish the line of the interaction that aborted the execution
+			break
+		}
 		if interaction.HasFailure() && context.FailureStops {
 			log.Printf("Stop requested after first failed test.")
 			break
 		}
 	}
-	fmt.Printf("%s: %d tests - %d successful, %d failures, %d errors\n", result(context.ReturnCode()), suite.TestCount(),
-		suite.SuccessCount(), suite.FailureCount(), suite.ErrorCount())
-	return suite, nil
+	// teardown directives are always executed, even after a stop was requested or an error aborted the execution
+	for _, interaction := range visitor.Teardown {
+		if err := perform(interaction); err != nil && failed == nil {
+			failed = err
+		}
+	}
+	if failed != nil {
+		return nil, failed
+	}
+	fmt.Fprintf(out, "%s: %d tests - %d successful, %d failures, %d errors, %d skipped\n", result(returnCode), suite.TestCount(),
+		suite.SuccessCount(), suite.FailureCount(), suite.ErrorCount(), suite.SkipCount())
+	if len(sandbox) > 0 && (returnCode != returnSuccess || context.KeepSandbox) {
+		if context.KeepSandbox {
+			fmt.Fprintf(out, "SHELLDOC: the sandbox of \"%s\" is kept in %s\n", inputfile, sandbox)
+		} else {
+			fmt.Fprintf(out, "SHELLDOC: the sandbox %s was removed; use --keep-sandbox to inspect it\n", sandbox)
+		}
+	}
+	if context.Update {
+		count, err := updateFile(inputfile, data, visitor.Interactions)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(out, "SHELLDOC: updated %d expected responses in \"%s\"\n", count, inputfile)
+	}
+	fileResult := &FileResult{Filename: inputfile, Suite: suite, Interactions: executed, Result: result(returnCode),
+		Duration: time.Since(started), Source: data}
+	summary := jsonFile(*fileResult, false)
+	context.emit(jsonreport.Event{Type: jsonreport.EventFileFinished, Filename: inputfile, File: &summary})
+	return fileResult, nil
+}
+
+// executor returns the executor for the commands of a file, a shell that runs locally, in a container or on a remote host
+func (context *Context) executor(inputfile string) (shell.Executor, error) {
+	if context.PTY {
+		return context.terminalExecutor(inputfile)
+	}
+	backend, err := context.backend(inputfile)
+	if err != nil {
+		return nil, err
+	}
+	return shell.NewShell(backend), nil
+}
+
+// terminalExecutor returns an executor for commands that require a terminal
+func (context *Context) terminalExecutor(inputfile string) (shell.Executor, error) {
+	if len(context.Container) > 0 || len(context.SSH) > 0 {
+		return nil, fmt.Errorf("pseudo-terminals are only supported for local shells")
+	}
+	backend, err := context.backend(inputfile)
+	if err != nil {
+		return nil, err
+	}
+	return shell.NewTerminalShell(backend, context.StripANSI), nil
+}
+
+// backend returns the backend that starts the shells locally, in a container or on a remote host
+// A container gets the directory of the input file, so that the commands can access the files next to it.
+func (context *Context) backend(inputfile string) (shell.Backend, error) {
+	switch {
+	case len(context.Container) > 0 && len(context.SSH) > 0:
+		return nil, fmt.Errorf("a container and a remote host cannot be used at the same time")
+	case len(context.Container) > 0:
+		runtime, err := shell.DetectContainerRuntime(context.ContainerRuntime)
+		if err != nil {
+			return nil, err
+		}
+		// the shell of the user may not exist in the container, it is only used if it is explicitly selected
+		return shell.ContainerBackend{Runtime: runtime, Image: context.Container, Shell: context.ShellName,
+			Mount: filepath.Dir(inputfile)}, nil
+	case len(context.SSH) > 0:
+		if context.Sandbox || len(context.Fixtures) > 0 {
+			return nil, fmt.Errorf("sandboxes are local directories, they cannot be used on a remote host")
+		}
+		client, err := exec.LookPath("ssh")
+		if err != nil {
+			return nil, fmt.Errorf("unable to find the ssh client: %v", err)
+		}
+		return shell.SSHBackend{Client: client, Destination: context.SSH, Options: context.SSHOptions, Shell: context.ShellName}, nil
+	default:
+		shellpath, err := shell.DetectShell(context.ShellName)
+		if err != nil {
+			return nil, err
+		}
+		return shell.LocalBackend{Shell: shellpath}, nil
+	}
 }
 
-func (context *Context) performTestCase(interaction *tokenizer.Interaction, shell shell.Shell) (*junitxml.JUnitTestCase, error) {
+func (context *Context) performTestCase(interaction *tokenizer.Interaction, shell shell.Executor) (*junitxml.JUnitTestCase, error) {
 	testcase := &junitxml.JUnitTestCase{
 		Name: interaction.Cmd,
 	}
+	if len(interaction.Caption) > 0 {
+		testcase.Name = interaction.Caption
+	}
 	defer junitxml.RegisterElapsedTime(time.Now(), &testcase.Time)
-	return testcase, interaction.Execute(&shell)
+	if interaction.ResultCode == tokenizer.ResultSkipped {
+		testcase.RegisterSkipped(interaction.Comment)
+		return testcase, nil
+	}
+	err := interaction.Execute(shell, context.Timeout)
+	if interaction.ResultCode == tokenizer.ResultExpectedFailure {
+		testcase.RegisterSkipped(fmt.Sprintf("%s: %s", interaction.Location(), interaction.Result()))
+	}
+	return testcase, err
+}
+
+// selectInteractions marks the interactions as skipped that should not be executed according to their options
+// If any interaction has the OnlyOption, all interactions without it are skipped.
+func selectInteractions(interactions []*tokenizer.Interaction) {
+	focused := false
+	for _, interaction := range interactions {
+		if _, ok := interaction.Attributes[tokenizer.OnlyOption]; ok {
+			focused = true
+		}
+	}
+	for _, interaction := range interactions {
+		_, only := interaction.Attributes[tokenizer.OnlyOption]
+		if reason, skip := interaction.Attributes[tokenizer.SkipOption]; skip {
+			if len(reason) == 0 {
+				reason = fmt.Sprintf("skipped using %s", tokenizer.SkipOption)
+			}
+			interaction.Skip(reason)
+		} else if focused && !only {
+			interaction.Skip(fmt.Sprintf("not selected using %s", tokenizer.OnlyOption))
+		}
+	}
+}
+
+// prompts returns the prompts configured for the run, or the default prompts
+func (context *Context) prompts() ([]tokenizer.Prompt, error) {
+	specs := context.Prompts
+	if len(specs) == 0 {
+		specs = tokenizer.DefaultPrompts
+	}
+	prompts, err := tokenizer.NewPrompts(specs, false)
+	if err != nil {
+		return nil, err
+	}
+	rootPrompts, err := tokenizer.NewPrompts(context.RootPrompts, true)
+	if err != nil {
+		return nil, err
+	}
+	return append(prompts, rootPrompts...), nil
 }
@@ -5,9 +5,18 @@ package run
 // SPDX-License-Identifier: Apache-2.0
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/endocode/shelldoc/pkg/ci"
+	"github.com/endocode/shelldoc/pkg/jsonreport"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
 	"github.com/stretchr/testify/require"
 )
 
@@ -38,6 +47,7 @@ func TestHFailNoMatch(t *testing.T) {
 	require.NoError(t, err, "The failnomatch example should fail with a mismatch.")
 	require.Equal(t, returnFailure, context.ReturnCode(), "The expected return code is returnFailure.")
 	require.Equal(t, 1, testsuite.FailureCount(), "There is one failing test in the sample.")
+	require.Contains(t, testsuite.TestCases[0].Failure.Contents, "failnomatch.md:5:", "The failure reports the location of the command.")
 }
 
 func TestExitCodesOptions(t *testing.T) {
@@ -46,3 +56,385 @@ func TestExitCodesOptions(t *testing.T) {
 	require.NoError(t, err, "The HelloWorld example should execute without errors.")
 	require.Equal(t, returnSuccess, context.ReturnCode(), "The expected return code is returnSuccess.")
 }
+
+func TestTimeout(t *testing.T) {
+	context := Context{}
+	testsuite, err := context.performInteractions("../../pkg/tokenizer/samples/timeout.md")
+	require.NoError(t, err, "The timeout example should execute without errors.")
+	require.Equal(t, returnError, context.ReturnCode(), "The expected return code is returnError.")
+	require.Equal(t, 1, testsuite.ErrorCount(), "The hanging command is reported as an error.")
+	require.Equal(t, 1, testsuite.SuccessCount(), "The command after the timeout is executed in a new shell.")
+}
+
+func TestErrorOutput(t *testing.T) {
+	context := Context{}
+	testsuite, err := context.performInteractions("../../pkg/tokenizer/samples/stderr.md")
+	require.NoError(t, err, "The stderr example should execute without errors.")
+	require.Equal(t, returnSuccess, context.ReturnCode(), "The expected return code is returnSuccess.")
+	require.Equal(t, 3, testsuite.SuccessCount(), "All three streams are compared successfully.")
+	require.Equal(t, "World", testsuite.TestCases[0].SystemErr, "The output to stderr is recorded in the test case.")
+}
+
+func TestRegex(t *testing.T) {
+	context := Context{}
+	testsuite, err := context.performInteractions("../../pkg/tokenizer/samples/regex.md")
+	require.NoError(t, err, "The regex example should execute without errors.")
+	require.Equal(t, returnFailure, context.ReturnCode(), "The expected return code is returnFailure.")
+	require.Equal(t, 2, testsuite.SuccessCount(), "Two responses match the regular expressions.")
+	require.Equal(t, 1, testsuite.FailureCount(), "One response does not match the regular expression.")
+	require.Contains(t, testsuite.TestCases[2].Failure.Contents, "line 2", "The failure names the mismatching line.")
+}
+
+func TestUpdate(t *testing.T) {
+	data, err := ioutil.ReadFile("../../pkg/tokenizer/samples/update.md")
+	require.NoError(t, err, "Unable to read sample data file")
+	file, err := ioutil.TempFile("", "update_test-*.md")
+	require.NoError(t, err, "Unable to create temporary file")
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	require.NoError(t, err, "Unable to write temporary file")
+	file.Close()
+
+	context := Context{Update: true}
+	_, err = context.performInteractions(file.Name())
+	require.NoError(t, err, "The update example should execute without errors.")
+	require.Equal(t, returnFailure, context.ReturnCode(), "The mismatches are reported when updating.")
+	updated, err := ioutil.ReadFile(file.Name())
+	require.NoError(t, err, "Unable to read updated file")
+	require.Contains(t, string(updated), "    $ echo Hello; echo World\n    Hello\n    World\n\n", "The indentation is preserved")
+	require.Contains(t, string(updated), "> echo Hello\nHello\n> echo World\nWorld\n```", "The missing response is inserted")
+	require.Contains(t, string(updated), "\t$ echo Hello; echo World\n\tHello\n\t...\n", "The ellipsis is preserved")
+	require.Contains(t, string(updated), "$ date +%Y; echo World\n[0-9]{4} (re)\nWorld\n```", "Matching regular expressions are preserved")
+	require.Contains(t, string(updated), "H.*\nv1\\.0 \\(beta\\)\n```", "Output in blocks of regular expressions is quoted")
+
+	context = Context{}
+	testsuite, err := context.performInteractions(file.Name())
+	require.NoError(t, err, "The updated example should execute without errors.")
+	require.Equal(t, returnSuccess, context.ReturnCode(), "The updated file passes.")
+	require.Equal(t, 6, testsuite.SuccessCount(), "All six interactions pass after the update.")
+}
+
+func TestMultiline(t *testing.T) {
+	context := Context{}
+	testsuite, err := context.performInteractions("../../pkg/tokenizer/samples/multiline.md")
+	require.NoError(t, err, "The multiline example should execute without errors.")
+	require.Equal(t, returnSuccess, context.ReturnCode(), "The expected return code is returnSuccess.")
+	require.Equal(t, 6, testsuite.SuccessCount(), "All multi-line commands are executed as one unit.")
+}
+
+func TestRootPrompts(t *testing.T) {
+	context := Context{RootPrompts: []string{"#"}, RootMode: RootModeSkip}
+	testsuite, err := context.performInteractions("../../pkg/tokenizer/samples/prompts.md")
+	require.NoError(t, err, "The prompts example should execute without errors.")
+	require.Equal(t, returnSuccess, context.ReturnCode(), "The expected return code is returnSuccess.")
+	require.Equal(t, 2, testsuite.SuccessCount(), "The commands with regular prompts pass.")
+	require.Equal(t, 3, testsuite.SkipCount(), "The commands that require root privileges are skipped.")
+	context = Context{RootMode: "sometimes"}
+	require.Error(t, context.Validate(), "Unknown root modes are reported.")
+	context = Context{RootMode: "sometimes", Files: []string{"../../pkg/tokenizer/samples/prompts.md"}}
+	require.Equal(t, returnError, context.ExecuteFiles(), "Unknown root modes are reported before any file is executed.")
+	require.Empty(t, context.Results, "No file is executed.")
+}
+
+func TestSkipAndExpectedFailure(t *testing.T) {
+	context := Context{}
+	testsuite, err := context.performInteractions("../../pkg/tokenizer/samples/selection.md")
+	require.NoError(t, err, "The selection example should execute without errors.")
+	require.Equal(t, returnFailure, context.ReturnCode(), "The unexpected success is a failure.")
+	require.Equal(t, 2, testsuite.SkipCount(), "The skipped command and the expected failure are reported as skipped.")
+	require.Equal(t, "broken-on-purpose", testsuite.TestCases[0].SkipMessage.Message, "The skip reason is reported.")
+	require.Equal(t, 1, testsuite.FailureCount(), "The unexpected success is reported as a failure.")
+}
+
+func TestOnly(t *testing.T) {
+	context := Context{}
+	testsuite, err := context.performInteractions("../../pkg/tokenizer/samples/only.md")
+	require.NoError(t, err, "The only example should execute without errors.")
+	require.Equal(t, returnSuccess, context.ReturnCode(), "The expected return code is returnSuccess.")
+	require.Equal(t, 1, testsuite.SkipCount(), "The command without shelldoconly is skipped.")
+	require.Equal(t, 1, testsuite.SuccessCount(), "The command with shelldoconly is executed.")
+}
+
+func TestSetupAndTeardown(t *testing.T) {
+	context := Context{FailureStops: true}
+	testsuite, err := context.performInteractions("../../pkg/tokenizer/samples/setup.md")
+	require.NoError(t, err, "The setup example should execute without errors.")
+	require.Equal(t, returnFailure, context.ReturnCode(), "The second command fails.")
+	require.Equal(t, 4, testsuite.TestCount(), "Setup and teardown are reported as test cases.")
+	require.Equal(t, 3, testsuite.SuccessCount(), "The setup script enables the first command, teardown runs after the failure.")
+	require.Equal(t, tokenizer.SetupDirective, testsuite.TestCases[0].Name, "The setup script is executed first.")
+	require.Equal(t, tokenizer.TeardownDirective, testsuite.TestCases[3].Name, "The teardown script is executed last.")
+}
+
+func TestTeardownAfterError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "teardown_test-")
+	require.NoError(t, err, "The temporary directory should be created.")
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "teardown")
+	context := Context{Env: []string{"TEARDOWN_MARKER=" + marker}}
+	_, err = context.performInteractions("../../pkg/tokenizer/samples/teardownerror.md")
+	require.Error(t, err, "The setup script cannot be replayed in the shell on a terminal.")
+	require.FileExists(t, marker, "The teardown script is executed after the error.")
+}
+
+func TestParallelFiles(t *testing.T) {
+	files := []string{
+		"../../pkg/tokenizer/samples/helloworld.md",
+		"../../pkg/tokenizer/samples/failnomatch.md",
+		"../../pkg/tokenizer/samples/options.md",
+		"../../pkg/tokenizer/samples/multiline.md",
+		"../../pkg/tokenizer/samples/stderr.md",
+	}
+	context := Context{Jobs: 4, Files: files}
+	returnCode := context.ExecuteFiles()
+	require.Equal(t, returnFailure, returnCode, "The failure in one file determines the overall result.")
+	require.Len(t, context.Suites.Suites, len(files), "Every file is reported as a test suite.")
+	for index, file := range files {
+		require.Equal(t, file, context.Suites.Suites[index].Name, "The test suites are reported in the order of the files.")
+		sandboxed := false
+		for _, property := range context.Suites.Suites[index].Properties {
+			sandboxed = sandboxed || property.Name == "shelldoc-sandbox"
+		}
+		require.True(t, sandboxed, "Files executed concurrently run in sandboxes.")
+	}
+	require.Equal(t, 1, context.Suites.Suites[1].FailureCount(), "The failure is reported in the suite of its file.")
+}
+
+func TestSandbox(t *testing.T) {
+	context := Context{Fixtures: "../../pkg/tokenizer/samples/fixtures", KeepSandbox: true}
+	testsuite, err := context.performInteractions("../../pkg/tokenizer/samples/sandbox.md")
+	require.NoError(t, err, "The sandbox example should execute without errors.")
+	require.Equal(t, returnSuccess, context.ReturnCode(), "The expected return code is returnSuccess.")
+	require.Equal(t, 2, testsuite.SuccessCount(), "The fixtures are available in the sandbox.")
+	var sandbox string
+	for _, property := range testsuite.Properties {
+		if property.Name == "shelldoc-sandbox" {
+			sandbox = property.Value
+		}
+	}
+	require.NotEmpty(t, sandbox, "The sandbox is recorded as a property.")
+	defer os.RemoveAll(sandbox)
+	require.FileExists(t, filepath.Join(sandbox, "created.txt"), "The created file is kept in the sandbox.")
+	_, err = os.Stat("created.txt")
+	require.True(t, os.IsNotExist(err), "The created file is not in the current directory.")
+
+	context = Context{Sandbox: true}
+	var buffer bytes.Buffer
+	result, err := context.performInteractionsTo("../../pkg/tokenizer/samples/sandbox.md", &buffer)
+	require.NoError(t, err, "The sandbox example should execute without errors.")
+	require.Equal(t, returnFailure, context.ReturnCode(), "Without fixtures, the sandbox is empty.")
+	sandbox = result.Suite.Properties[len(result.Suite.Properties)-1].Value
+	_, err = os.Stat(sandbox)
+	require.True(t, os.IsNotExist(err), "The sandbox is removed after the run.")
+	require.Contains(t, buffer.String(), "the sandbox "+sandbox+" was removed; use --keep-sandbox to inspect it",
+		"The removal of the sandbox is reported.")
+}
+
+func TestCleanEnvironment(t *testing.T) {
+	os.Setenv("SHELLDOC_SECRET", "leaked")
+	defer os.Unsetenv("SHELLDOC_SECRET")
+	file, err := ioutil.TempFile("", "environment_test-*.env")
+	require.NoError(t, err, "Unable to create temporary file")
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("# the greeting\nexport GREETING=\"Hello World\"\nTERM=xterm\n")
+	require.NoError(t, err, "Unable to write temporary file")
+	file.Close()
+
+	context := Context{CleanEnv: true, EnvFile: file.Name(), Env: []string{"TERM=dumb"}}
+	testsuite, err := context.performInteractions("../../pkg/tokenizer/samples/environment.md")
+	require.NoError(t, err, "The environment example should execute without errors.")
+	require.Equal(t, returnSuccess, context.ReturnCode(), "The expected return code is returnSuccess.")
+	require.Equal(t, 3, testsuite.SuccessCount(), "The shell only sees the configured variables.")
+	properties := make(map[string]string)
+	for _, property := range testsuite.Properties {
+		properties[property.Name] = property.Value
+	}
+	require.Equal(t, "C", properties["env.LC_ALL"], "The default variables are recorded.")
+	require.Equal(t, "dumb", properties["env.TERM"], "Variables set on the command line override the file.")
+	require.Equal(t, os.Getenv("PATH"), properties["env.PATH"], "PATH is passed through by default.")
+	require.NotContains(t, properties, "env.SHELLDOC_SECRET", "Other variables are not passed through.")
+
+	context = Context{Env: []string{"GREETING=Hello World"}}
+	testsuite, err = context.performInteractions("../../pkg/tokenizer/samples/environment.md")
+	require.NoError(t, err, "The environment example should execute without errors.")
+	require.Nil(t, testsuite.TestCases[1].Failure, "The greeting is set in the inherited environment.")
+	require.NotNil(t, testsuite.TestCases[2].Failure, "Without a clean environment, the variables are inherited.")
+
+	context = Context{Env: []string{"GREETING"}}
+	_, err = context.performInteractions("../../pkg/tokenizer/samples/environment.md")
+	require.Error(t, err, "Variables without a value are reported.")
+}
+
+func TestRemoteEnvironment(t *testing.T) {
+	context := Context{CleanEnv: true, Env: []string{"GREETING=Hello"}}
+	env, recorded, err := context.environment(true)
+	require.NoError(t, err, "The remote environment should be set up without errors.")
+	require.Equal(t, []string{"LC_ALL=C", "TERM=dumb", "GREETING=Hello"}, env, "Local variables like PATH are not passed.")
+	require.Equal(t, env, recorded, "The whole remote environment is recorded.")
+	context = Context{Env: []string{"GREETING=Hello"}}
+	env, _, err = context.environment(true)
+	require.NoError(t, err, "The remote environment should be set up without errors.")
+	require.Equal(t, []string{"GREETING=Hello"}, env, "Only the variables set explicitly are passed.")
+}
+
+func TestTerminal(t *testing.T) {
+	context := Context{StripANSI: true}
+	testsuite, err := context.performInteractions("../../pkg/tokenizer/samples/terminal.md")
+	require.NoError(t, err, "The terminal example should execute without errors.")
+	require.Equal(t, returnSuccess, context.ReturnCode(), "The expected return code is returnSuccess.")
+	require.Equal(t, 3, testsuite.SuccessCount(), "Only the commands with shelldocpty run on a terminal.")
+
+	context = Context{PTY: true, StripANSI: false}
+	testsuite, err = context.performInteractions("../../pkg/tokenizer/samples/terminal.md")
+	require.NoError(t, err, "The terminal example should execute without errors.")
+	require.Equal(t, returnFailure, context.ReturnCode(), "The expected return code is returnFailure.")
+	require.Equal(t, 1, testsuite.SuccessCount(), "All commands run on a terminal, the colors are not removed.")
+	require.Equal(t, 2, testsuite.FailureCount(), "The colors and the last command fail.")
+}
+
+func TestTerminalSetup(t *testing.T) {
+	context := Context{StripANSI: true}
+	testsuite, err := context.performInteractions("../../pkg/tokenizer/samples/terminalsetup.md")
+	require.NoError(t, err, "The terminal setup example should execute without errors.")
+	require.Equal(t, returnSuccess, context.ReturnCode(), "The expected return code is returnSuccess.")
+	require.Equal(t, 2, testsuite.SuccessCount(), "The setup script is executed in the shell on a terminal.")
+}
+
+func TestShellExited(t *testing.T) {
+	context := Context{}
+	testsuite, err := context.performInteractions("../../pkg/tokenizer/samples/exit.md")
+	require.NoError(t, err, "The exit example should execute without errors.")
+	require.Equal(t, returnError, context.ReturnCode(), "The exit of the shell is an error.")
+	require.Equal(t, 1, testsuite.ErrorCount(), "The command that exits the shell is reported as an error.")
+	require.Contains(t, testsuite.TestCases[2].Error.Contents, "exit code 3", "The exit code of the shell is reported.")
+	require.Equal(t, 1, testsuite.SkipCount(), "The commands after the exit are skipped.")
+
+	context = Context{RestartShell: true}
+	testsuite, err = context.performInteractions("../../pkg/tokenizer/samples/exit.md")
+	require.NoError(t, err, "The exit example should execute without errors.")
+	require.Equal(t, returnError, context.ReturnCode(), "The exit of the shell is an error.")
+	require.Equal(t, 1, testsuite.ErrorCount(), "The command that exits the shell is reported as an error.")
+	require.Equal(t, 3, testsuite.SuccessCount(), "The shell is restarted and the setup script is executed again.")
+}
+
+func TestTAPReport(t *testing.T) {
+	file, err := ioutil.TempFile("", "tap_test-*.tap")
+	require.NoError(t, err, "Unable to create temporary file")
+	file.Close()
+	defer os.Remove(file.Name())
+
+	context := Context{Files: []string{"../../pkg/tokenizer/samples/selection.md"}, TAPOutputFile: file.Name()}
+	require.Equal(t, returnFailure, context.ExecuteFiles(), "The unexpected success is a failure.")
+	data, err := ioutil.ReadFile(file.Name())
+	require.NoError(t, err, "Unable to read TAP report")
+	report := string(data)
+	require.Contains(t, report, "TAP version 13\n1..", "The report starts with the version and the plan")
+	require.Contains(t, report, "# ../../pkg/tokenizer/samples/selection.md\n", "The file is named in a comment")
+	require.Regexp(t, `(?m)^ok 1 - .*selection\.md:\d+ .* # SKIP broken-on-purpose$`, report, "Skipped commands use the SKIP directive")
+	require.Regexp(t, `(?m)^not ok \d+ - .* # TODO XFAIL \(expected failure\)$`, report, "Expected failures use the TODO directive")
+	require.Regexp(t, `(?m)^not ok \d+ - [^#]*$`, report, "The unexpected success is a failure")
+	require.Contains(t, report, "  message: \"FAIL (unexpected success)\"\n", "The result is reported in the diagnostics")
+	require.Regexp(t, `(?m)^  exitcode: \d+$`, report, "The exit code is reported in the diagnostics")
+}
+
+func TestJSONReport(t *testing.T) {
+	file, err := ioutil.TempFile("", "json_test-*.json")
+	require.NoError(t, err, "Unable to create temporary file")
+	file.Close()
+	defer os.Remove(file.Name())
+
+	context := Context{Files: []string{"../../pkg/tokenizer/samples/selection.md"}, JSONOutputFile: file.Name()}
+	require.Equal(t, returnFailure, context.ExecuteFiles(), "The unexpected success is a failure.")
+	data, err := ioutil.ReadFile(file.Name())
+	require.NoError(t, err, "Unable to read JSON report")
+	var report jsonreport.Report
+	require.NoError(t, json.Unmarshal(data, &report), "The report should be valid JSON")
+	require.Equal(t, jsonreport.SchemaVersion, report.SchemaVersion, "The report has the schema version")
+	require.Equal(t, "FAILURE", report.Result)
+	require.Len(t, report.Files, 1)
+	require.Equal(t, 3, report.Files[0].Tests)
+	interactions := report.Files[0].Interactions
+	require.Len(t, interactions, 3)
+	require.Equal(t, jsonreport.StatusSkip, interactions[0].Status, "The first command is skipped")
+	require.Equal(t, "broken-on-purpose", interactions[0].Attributes[tokenizer.SkipOption])
+	require.Equal(t, jsonreport.StatusExpectedFailure, interactions[1].Status, "The second command fails as expected")
+	require.Equal(t, []string{"World"}, interactions[1].Expected)
+	require.Equal(t, []string{"Hello"}, interactions[1].Output)
+	require.Equal(t, jsonreport.StatusFail, interactions[2].Status, "The unexpected success is a failure")
+	require.Equal(t, tokenizer.ResultUnexpectedSuccess, interactions[2].ResultCode)
+	require.Equal(t, "echo Hello", interactions[2].Command)
+	require.Equal(t, 19, interactions[2].Location.Line, "The location of the command is reported")
+	require.Equal(t, 20, interactions[2].Location.EndLine, "The location includes the expected response")
+}
+
+func TestJSONStream(t *testing.T) {
+	var buffer bytes.Buffer
+	context := Context{events: jsonreport.NewStream(&buffer)}
+	_, err := context.performInteractionsTo("../../pkg/tokenizer/samples/selection.md", ioutil.Discard)
+	require.NoError(t, err)
+	var types []string
+	scanner := bufio.NewScanner(&buffer)
+	for scanner.Scan() {
+		var event jsonreport.Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event), "Every line should be a JSON event")
+		types = append(types, event.Type)
+		if event.Type == jsonreport.EventFileFinished {
+			require.Equal(t, 1, event.File.Failures, "The summary of the file is part of the last event")
+			require.Empty(t, event.File.Interactions, "The summary does not repeat the interactions")
+		}
+	}
+	require.Equal(t, []string{jsonreport.EventFileStarted,
+		jsonreport.EventInteractionStarted, jsonreport.EventInteractionFinished,
+		jsonreport.EventInteractionStarted, jsonreport.EventInteractionFinished,
+		jsonreport.EventInteractionStarted, jsonreport.EventInteractionFinished,
+		jsonreport.EventFileFinished}, types, "The events are emitted as the run progresses")
+}
+
+func TestHTMLReport(t *testing.T) {
+	file, err := ioutil.TempFile("", "html_test-*.html")
+	require.NoError(t, err, "Unable to create temporary file")
+	file.Close()
+	defer os.Remove(file.Name())
+
+	context := Context{Files: []string{"../../pkg/tokenizer/samples/setup.md"}, HTMLOutputFile: file.Name()}
+	require.Equal(t, returnFailure, context.ExecuteFiles(), "The sample contains a failing command.")
+	data, err := ioutil.ReadFile(file.Name())
+	require.NoError(t, err, "Unable to read HTML report")
+	document := string(data)
+	require.Contains(t, document, "<h1>Test: hidden setup and teardown scripts</h1>", "The Markdown file is rendered")
+	require.Contains(t, document, `<span class="badge pass">PASS (match)</span> <code>shelldoc-setup</code>`, "The setup script is reported")
+	require.Contains(t, document, `<span class="badge fail">FAIL (mismatch)</span>`, "The failure is reported")
+	require.Contains(t, document, `<span class="added">&#43;Goodbye</span>`, "The diff is shown")
+}
+
+func TestCodeQualityReport(t *testing.T) {
+	file, err := ioutil.TempFile("", "codequality_test-*.json")
+	require.NoError(t, err, "Unable to create temporary file")
+	file.Close()
+	defer os.Remove(file.Name())
+
+	context := Context{Files: []string{"../../pkg/tokenizer/samples/setup.md"}, CodeQualityFile: file.Name()}
+	require.Equal(t, returnFailure, context.ExecuteFiles(), "The sample contains a failing command.")
+	data, err := ioutil.ReadFile(file.Name())
+	require.NoError(t, err, "Unable to read Code Quality report")
+	var issues []ci.Issue
+	require.NoError(t, json.Unmarshal(data, &issues), "The report should be valid JSON")
+	require.Len(t, issues, 1, "Only the failing command is reported")
+	require.Equal(t, "../../pkg/tokenizer/samples/setup.md", issues[0].Location.Path)
+	require.Equal(t, 16, issues[0].Location.Lines.Begin, "The issue is reported on the line of the command")
+	require.Equal(t, ci.SeverityMajor, issues[0].Severity)
+}
+
+func TestGitHubFormat(t *testing.T) {
+	var buffer bytes.Buffer
+	context := Context{Format: FormatGitHub}
+	_, err := context.performInteractionsTo("../../pkg/tokenizer/samples/setup.md", &buffer)
+	require.NoError(t, err)
+	require.Contains(t, buffer.String(),
+		"\n::error file=../../pkg/tokenizer/samples/setup.md,line=16,endLine=17,title=touch created && echo Goodbye%3A FAIL (mismatch)::FAIL (mismatch)%0A--- expected%0A+++ actual%0A",
+		"Failures are reported as workflow commands")
+	require.Equal(t, 1, strings.Count(buffer.String(), "::error"), "Only failures are reported")
+
+	context = Context{Format: "xml"}
+	require.Error(t, context.Validate(), "Unknown formats are rejected")
+}
@@ -0,0 +1,124 @@
+package run
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/endocode/shelldoc/pkg/jsonreport"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+	"github.com/endocode/shelldoc/pkg/version"
+)
+
+// WriteJSON writes the test results to the specified JSON output file
+func (context *Context) WriteJSON() error {
+	if len(context.JSONOutputFile) > 0 {
+		file, err := os.OpenFile(context.JSONOutputFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+		if err != nil {
+			return fmt.Errorf("unable to open JSON output file for writing: %v", err)
+		}
+		defer file.Close()
+		report := jsonreport.Report{Version: version.Version(), Result: result(context.ReturnCode())}
+		for _, fileResult := range context.Results {
+			report.Files = append(report.Files, jsonFile(fileResult, true))
+		}
+		if err := report.Write(file); err != nil {
+			return fmt.Errorf("error writing JSON output file: %v", err)
+		}
+	}
+	return nil
+}
+
+// emit writes an event to the JSON event stream, if it is enabled
+func (context *Context) emit(event jsonreport.Event) {
+	if context.events == nil {
+		return
+	}
+	if err := context.events.Emit(event); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to write JSON event: %v\n", err)
+	}
+}
+
+// jsonFile converts the results of a file, the interactions are only included if requested
+func jsonFile(result FileResult, interactions bool) jsonreport.File {
+	suite := result.Suite
+	file := jsonreport.File{
+		Filename:   result.Filename,
+		Result:     result.Result,
+		Tests:      suite.TestCount(),
+		Successes:  suite.SuccessCount(),
+		Failures:   suite.FailureCount(),
+		Errors:     suite.ErrorCount(),
+		Skipped:    suite.SkipCount(),
+		Duration:   result.Duration.Seconds(),
+		Properties: make(map[string]string),
+	}
+	for _, property := range suite.Properties {
+		file.Properties[property.Name] = property.Value
+	}
+	if interactions {
+		file.Interactions = []jsonreport.Interaction{}
+		for _, interaction := range result.Interactions {
+			file.Interactions = append(file.Interactions, jsonInteraction(interaction))
+		}
+	}
+	return file
+}
+
+// jsonInteraction converts an interaction, before or after it was executed
+func jsonInteraction(interaction *tokenizer.Interaction) jsonreport.Interaction {
+	lines := func(text []string) []string {
+		if text == nil {
+			return []string{} // an empty list is easier to process than null
+		}
+		return text
+	}
+	converted := jsonreport.Interaction{
+		Caption:     interaction.Caption,
+		Command:     interaction.Cmd,
+		Language:    interaction.Language,
+		Attributes:  interaction.Attributes,
+		Expected:    lines(interaction.Response),
+		Output:      lines(interaction.Output),
+		ErrorOutput: lines(interaction.ErrorOutput),
+		ExitCode:    interaction.ExitCode,
+		ResultCode:  interaction.ResultCode,
+		Result:      interaction.Result(),
+		Status:      status(interaction),
+		Comment:     interaction.Comment,
+		Duration:    interaction.Duration.Seconds(),
+		Location: jsonreport.Location{
+			Filename: interaction.Filename,
+			Line:     interaction.CommandSpan.FirstLine,
+			EndLine:  interaction.CommandSpan.LastLine,
+		},
+	}
+	if interaction.ResponseSpan.Known() && !interaction.ResponseSpan.Empty() {
+		converted.Location.EndLine = interaction.ResponseSpan.LastLine
+	}
+	if interaction.HasFailure() {
+		converted.Diff = interaction.Diff()
+	}
+	return converted
+}
+
+// status classifies the result of an interaction
+func status(interaction *tokenizer.Interaction) string {
+	switch interaction.ResultCode {
+	case tokenizer.NewInteraction:
+		return jsonreport.StatusPending
+	case tokenizer.ResultMatch, tokenizer.ResultRegexMatch:
+		return jsonreport.StatusPass
+	case tokenizer.ResultSkipped:
+		return jsonreport.StatusSkip
+	case tokenizer.ResultExpectedFailure:
+		return jsonreport.StatusExpectedFailure
+	case tokenizer.ResultExecutionError, tokenizer.ResultTimeout, tokenizer.ResultShellExited:
+		return jsonreport.StatusError
+	default:
+		return jsonreport.StatusFail
+	}
+}
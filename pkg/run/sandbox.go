@@ -0,0 +1,86 @@
+package run
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// sandboxed returns true if every file is executed in a sandbox directory
+// Files that are executed concurrently always get one, so that they cannot modify each other's files. Remote hosts
+// have no local sandboxes, files executed there are not isolated.
+func (context *Context) sandboxed() bool {
+	return context.Sandbox || len(context.Fixtures) > 0 || context.Jobs > 1 && len(context.SSH) == 0
+}
+
+// createSandbox creates a new temporary directory to execute the commands of a file in
+// If fixtures is not empty, the content of the fixtures directory is copied into the sandbox.
+func createSandbox(fixtures string) (string, error) {
+	sandbox, err := ioutil.TempDir("", "shelldoc-")
+	if err != nil {
+		return "", fmt.Errorf("unable to create sandbox directory: %v", err)
+	}
+	if len(fixtures) > 0 {
+		if err := copyTree(fixtures, sandbox); err != nil {
+			os.RemoveAll(sandbox)
+			return "", fmt.Errorf("unable to copy fixtures from %s into the sandbox: %v", fixtures, err)
+		}
+	}
+	return sandbox, nil
+}
+
+// copyTree copies the content of the source directory into the existing target directory
+// Symbolic links are copied as links, the permissions of files and directories are preserved.
+func copyTree(source, target string) error {
+	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		destination := filepath.Join(target, relative)
+		switch {
+		case info.IsDir():
+			if relative == "." {
+				return nil // the target directory already exists
+			}
+			return os.Mkdir(destination, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, destination)
+		case info.Mode().IsRegular():
+			return copyFile(path, destination, info.Mode().Perm())
+		default:
+			return fmt.Errorf("unable to copy %s, it is not a regular file, directory or symbolic link", path)
+		}
+	})
+}
+
+// copyFile copies the content of the source file into a new file with the specified permissions
+func copyFile(source, destination string, mode os.FileMode) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
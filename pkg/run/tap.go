@@ -0,0 +1,90 @@
+package run
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/endocode/shelldoc/pkg/tap"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// WriteTAP writes the test results to the specified TAP output file
+func (context *Context) WriteTAP() error {
+	if len(context.TAPOutputFile) > 0 {
+		file, err := os.OpenFile(context.TAPOutputFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+		if err != nil {
+			return fmt.Errorf("unable to open TAP output file for writing: %v", err)
+		}
+		defer file.Close()
+		if err := tapReport(context.Results).Write(file); err != nil {
+			return fmt.Errorf("error writing TAP output file: %v", err)
+		}
+	}
+	return nil
+}
+
+// tapReport converts the results into a TAP report with one test point per interaction
+// Skipped interactions use the SKIP directive, expected failures the TODO directive.
+func tapReport(results []FileResult) tap.Report {
+	var report tap.Report
+	for _, result := range results {
+		for index, interaction := range result.Interactions {
+			point := tap.Point{
+				OK:          true,
+				Description: fmt.Sprintf("%s %s", interaction.Location(), name(interaction)),
+			}
+			if index == 0 {
+				point.Comment = result.Filename
+			}
+			switch interaction.ResultCode {
+			case tokenizer.ResultMatch, tokenizer.ResultRegexMatch:
+			case tokenizer.ResultSkipped:
+				point.Directive = tap.Skip
+				point.Explanation = interaction.Comment
+			case tokenizer.ResultExpectedFailure:
+				point.OK = false
+				point.Directive = tap.Todo
+				point.Explanation = interaction.Result()
+				point.Diagnostics = tapDiagnostics(interaction)
+			default:
+				point.OK = false
+				point.Diagnostics = tapDiagnostics(interaction)
+			}
+			report.AddPoint(point)
+		}
+	}
+	return report
+}
+
+// tapDiagnostics returns the details of an interaction that did not pass
+func tapDiagnostics(interaction *tokenizer.Interaction) []tap.Diagnostic {
+	diagnostics := []tap.Diagnostic{
+		{Key: "message", Value: interaction.Result()},
+	}
+	if len(interaction.Comment) > 0 {
+		diagnostics = append(diagnostics, tap.Diagnostic{Key: "comment", Value: interaction.Comment})
+	}
+	diagnostics = append(diagnostics,
+		tap.Diagnostic{Key: "location", Value: interaction.Location()},
+		tap.Diagnostic{Key: "command", Value: interaction.Cmd},
+		tap.Diagnostic{Key: "expected", Value: interaction.Response},
+		tap.Diagnostic{Key: "actual", Value: interaction.Output},
+	)
+	if len(interaction.ErrorOutput) > 0 {
+		diagnostics = append(diagnostics, tap.Diagnostic{Key: "stderr", Value: interaction.ErrorOutput})
+	}
+	return append(diagnostics, tap.Diagnostic{Key: "exitcode", Value: interaction.ExitCode})
+}
+
+// name returns the caption of an interaction, or its command in one line
+func name(interaction *tokenizer.Interaction) string {
+	if len(interaction.Caption) > 0 {
+		return interaction.Caption
+	}
+	return strings.Replace(interaction.Cmd, "\n", " ", -1)
+}
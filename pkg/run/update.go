@@ -0,0 +1,74 @@
+package run
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// updateResponses replaces the expected responses of mismatching interactions with their actual output
+// It returns the updated data and the number of updated responses.
+func updateResponses(data []byte, interactions []*tokenizer.Interaction) ([]byte, int) {
+	var updates []*tokenizer.Interaction
+	for _, interaction := range interactions {
+		if interaction.ResultCode == tokenizer.ResultMismatch && interaction.CommandSpan.Known() {
+			updates = append(updates, interaction)
+		}
+	}
+	// splice from the end of the data to the beginning, so that the offsets of the remaining spans stay valid
+	sort.Slice(updates, func(i, j int) bool {
+		return updates[i].ResponseSpan.Offset > updates[j].ResponseSpan.Offset
+	})
+	result := data
+	for _, interaction := range updates {
+		span := interaction.ResponseSpan
+		indentation := indentationAt(data, interaction.CommandSpan.Offset)
+		var response bytes.Buffer
+		if span.Offset > 0 && data[span.Offset-1] != '\n' {
+			// the command is on the last line of the file, which does not end in a newline
+			response.WriteString("\n")
+		}
+		for _, line := range interaction.UpdatedResponse() {
+			response.WriteString(indentation + line + "\n")
+		}
+		var spliced []byte
+		spliced = append(spliced, result[:span.Offset]...)
+		spliced = append(spliced, response.Bytes()...)
+		spliced = append(spliced, result[span.Offset+span.Length:]...)
+		result = spliced
+	}
+	return result, len(updates)
+}
+
+// indentationAt returns the white space at the beginning of the line that starts at offset
+func indentationAt(data []byte, offset int) string {
+	end := offset
+	for end < len(data) && (data[end] == ' ' || data[end] == '\t') {
+		end++
+	}
+	return string(data[offset:end])
+}
+
+// updateFile writes the actual output of mismatching interactions back into the Markdown file as the expected responses
+func updateFile(inputfile string, data []byte, interactions []*tokenizer.Interaction) (int, error) {
+	updated, count := updateResponses(data, interactions)
+	if count == 0 {
+		return 0, nil
+	}
+	info, err := os.Stat(inputfile)
+	if err != nil {
+		return 0, fmt.Errorf("unable to update input file: %v", err)
+	}
+	if err := ioutil.WriteFile(inputfile, updated, info.Mode()); err != nil {
+		return 0, fmt.Errorf("unable to update input file: %v", err)
+	}
+	return count, nil
+}
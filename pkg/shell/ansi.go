@@ -0,0 +1,18 @@
+package shell
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import "regexp"
+
+// ansiEx matches ANSI escape sequences: control sequences (like colors), operating system commands (like window
+// titles) and two-character escape sequences
+const ansiEx = "\x1b\\[[0-?]*[ -/]*[@-~]|\x1b\\][^\x07\x1b]*(?:\x07|\x1b\\\\)|\x1b[@-Z\\\\-_]"
+
+var ansiRx = regexp.MustCompile(ansiEx)
+
+// StripANSI removes ANSI escape sequences from a line of terminal output
+func StripANSI(line string) string {
+	return ansiRx.ReplaceAllString(line, "")
+}
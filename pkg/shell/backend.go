@@ -0,0 +1,203 @@
+package shell
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// Backend creates the process that runs the shell, for example locally or in a container
+type Backend interface {
+	// Command returns the command that starts the shell in the working directory dir with the environment env
+	// If dir is empty, the current directory is used. If env is nil, the backend decides about the environment.
+	Command(dir string, env []string) (*exec.Cmd, error)
+	// Kill terminates a shell started from the command and all processes started from it
+	Kill(cmd *exec.Cmd) error
+}
+
+// LocalBackend runs the shell as a local process
+type LocalBackend struct {
+	// Shell is the path to the shell executable
+	Shell string
+}
+
+// Command returns the command that starts the shell locally, if env is nil, the shell inherits the environment
+func (backend LocalBackend) Command(dir string, env []string) (*exec.Cmd, error) {
+	cmd := exec.Command(backend.Shell)
+	cmd.Dir = dir
+	cmd.Env = env
+	// the shell gets its own process group, so that Kill can also terminate the commands started from it
+	setProcessGroup(cmd)
+	return cmd, nil
+}
+
+// Kill terminates the process group of the shell
+func (backend LocalBackend) Kill(cmd *exec.Cmd) error {
+	return killProcessGroup(cmd)
+}
+
+// ContainerRuntimes are the container runtimes that are detected, in order of preference
+var ContainerRuntimes = []string{"docker", "podman"}
+
+// DefaultContainerShell is the shell that is started in the container if no other shell is specified
+const DefaultContainerShell = "/bin/sh"
+
+// containerCounter makes the names of the containers started by this process unique
+var containerCounter int64
+
+// ContainerBackend runs the shell in a new container that is removed when the shell exits
+// The directory Mount is mounted into the container under the same path, the shell starts in it. If the working
+// directory of the shell is another one, like a sandbox, it is mounted as well and the shell starts there.
+type ContainerBackend struct {
+	// Runtime is the container runtime executable, like docker or podman
+	Runtime string
+	// Image is the container image the shell is executed in
+	Image string
+	// Shell is the path to the shell executable in the container
+	Shell string
+	// Mount is the directory that is available in the container, usually the directory of the Markdown file
+	// If it is empty, the current directory is mounted.
+	Mount string
+}
+
+// DetectContainerRuntime returns the path to the selected container runtime, or the first of ContainerRuntimes that is installed
+func DetectContainerRuntime(selected string) (string, error) {
+	candidates := ContainerRuntimes
+	if len(selected) > 0 {
+		candidates = []string{selected}
+	}
+	for _, candidate := range candidates {
+		if path, err := exec.LookPath(candidate); err == nil {
+			return path, nil
+		}
+	}
+	if len(selected) > 0 {
+		return "", fmt.Errorf("the selected container runtime %s is not installed", selected)
+	}
+	return "", fmt.Errorf("no container runtime found (tried %v)", ContainerRuntimes)
+}
+
+// Command returns the command that starts the shell in a new container, the variables in env are set in the container
+func (backend ContainerBackend) Command(dir string, env []string) (*exec.Cmd, error) {
+	mount := backend.Mount
+	if len(mount) == 0 {
+		var err error
+		if mount, err = os.Getwd(); err != nil {
+			return nil, fmt.Errorf("unable to determine working directory: %v", err)
+		}
+	}
+	mount, err := filepath.Abs(mount)
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine the directory to mount: %v", err)
+	}
+	mounts := []string{mount}
+	workdir := mount
+	if len(dir) > 0 {
+		if workdir, err = filepath.Abs(dir); err != nil {
+			return nil, fmt.Errorf("unable to determine working directory: %v", err)
+		}
+		if workdir != mount {
+			mounts = append(mounts, workdir)
+		}
+	}
+	shell := backend.Shell
+	if len(shell) == 0 {
+		shell = DefaultContainerShell
+	}
+	name := fmt.Sprintf("shelldoc-%d-%d", os.Getpid(), atomic.AddInt64(&containerCounter, 1))
+	args := []string{"run", "--interactive", "--rm", "--name", name}
+	for _, directory := range mounts {
+		args = append(args, "--volume", fmt.Sprintf("%s:%s", directory, directory))
+	}
+	args = append(args, "--workdir", workdir)
+	for _, variable := range env {
+		args = append(args, "--env", variable)
+	}
+	args = append(args, backend.Image, shell)
+	cmd := exec.Command(backend.Runtime, args...)
+	setProcessGroup(cmd)
+	return cmd, nil
+}
+
+// Kill removes the container of the shell and terminates the container runtime client
+func (backend ContainerBackend) Kill(cmd *exec.Cmd) error {
+	// killing the client does not stop the container, it is removed by name
+	name := containerName(cmd)
+	if err := exec.Command(backend.Runtime, "rm", "--force", name).Run(); err != nil {
+		return fmt.Errorf("unable to remove container %s: %v", name, err)
+	}
+	return LocalBackend{}.Kill(cmd)
+}
+
+// containerName returns the name of the container started by a command created by ContainerBackend
+func containerName(cmd *exec.Cmd) string {
+	for index, arg := range cmd.Args[:len(cmd.Args)-1] {
+		if arg == "--name" {
+			return cmd.Args[index+1]
+		}
+	}
+	return ""
+}
+
+// DefaultRemoteShell is the shell that is started on the remote host if no other shell is specified
+const DefaultRemoteShell = "/bin/sh"
+
+// SSHBackend runs the shell on a remote host in a persistent session of the ssh client of the system
+// The ssh configuration of the user applies. Authentication needs to work without interaction, for example using keys.
+type SSHBackend struct {
+	// Client is the path to the ssh client executable
+	Client string
+	// Destination is the remote host, like user@host, or ssh://user@host:port
+	Destination string
+	// Options are passed to the ssh client, like "Port=2222"
+	Options []string
+	// Shell is the path to the shell executable on the remote host
+	Shell string
+}
+
+// Command returns the command that starts the shell on the remote host
+// The working directory dir is a directory on the remote host. The variables in env are set on the remote host.
+func (backend SSHBackend) Command(dir string, env []string) (*exec.Cmd, error) {
+	shell := backend.Shell
+	if len(shell) == 0 {
+		shell = DefaultRemoteShell
+	}
+	// the remote command is interpreted by the login shell of the user on the remote host
+	var remote []string
+	if len(dir) > 0 {
+		remote = append(remote, "cd", Quote(dir), "&&")
+	}
+	remote = append(remote, "exec")
+	if len(env) > 0 {
+		remote = append(remote, "env")
+		for _, variable := range env {
+			remote = append(remote, Quote(variable))
+		}
+	}
+	remote = append(remote, Quote(shell))
+	args := []string{"-T", "-o", "BatchMode=yes"}
+	for _, option := range backend.Options {
+		args = append(args, "-o", option)
+	}
+	args = append(args, "--", backend.Destination, strings.Join(remote, " "))
+	cmd := exec.Command(backend.Client, args...)
+	setProcessGroup(cmd)
+	return cmd, nil
+}
+
+// Kill terminates the ssh client, which closes the session on the remote host
+func (backend SSHBackend) Kill(cmd *exec.Cmd) error {
+	return LocalBackend{}.Kill(cmd)
+}
+
+// Quote returns the text as a single-quoted shell word, single quotes in the text are escaped
+func Quote(text string) string {
+	return "'" + strings.ReplaceAll(text, "'", "'\\''") + "'"
+}
@@ -0,0 +1,35 @@
+package shell
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import "time"
+
+// Executor executes the commands of the interactions in a session that keeps its state between the commands
+// Shell is the implementation that drives a shell process started by a Backend. Other implementations may
+// execute commands in a different way, for example fake executors in unit tests.
+type Executor interface {
+	// Start starts a new session in the working directory dir with the environment env
+	// It is also used to restart the session after it has been killed.
+	Start(dir string, env []string) error
+	// ExecuteCommand runs a command and returns its output to stdout and stderr and its exit code
+	// If timeout is larger than zero and the command does not finish in time, ErrTimeout is returned.
+	ExecuteCommand(command string, timeout time.Duration) ([]string, []string, int, error)
+	// Exit ends the session and waits for it
+	Exit() error
+	// Kill terminates the session and all processes started from it
+	Kill() error
+	// Capabilities describes what the executor supports
+	Capabilities() Capabilities
+}
+
+// Capabilities describes the features an Executor supports
+type Capabilities struct {
+	// Timeout is true if commands that take too long can be interrupted
+	Timeout bool
+	// ErrorOutput is true if the output to stderr is returned separately from the output to stdout
+	ErrorOutput bool
+	// Terminal is true if the commands are executed on a (pseudo-)terminal
+	Terminal bool
+}
@@ -0,0 +1,27 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package shell
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// setProcessGroup does nothing, process groups are only supported on Unix systems
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup terminates only the process of the command, process groups are only supported on Unix systems
+func killProcessGroup(cmd *exec.Cmd) error {
+	if err := cmd.Process.Kill(); err != nil {
+		return fmt.Errorf("unable to kill shell process: %v", err)
+	}
+	return nil
+}
+
+// setControllingTerminal does nothing, pseudo-terminals are only supported on Linux
+func setControllingTerminal(cmd *exec.Cmd) {}
@@ -6,21 +6,58 @@ package shell
 
 import (
 	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
-	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
+// ErrTimeout is returned by ExecuteCommand if the command did not finish within the specified timeout.
+// The state of the shell is undefined after a timeout, it should be killed and replaced by a new one.
+var ErrTimeout = errors.New("command timed out")
+
+// errShellExited is returned by readSection if the output of the shell ended before the end marker
+var errShellExited = errors.New("the shell exited before the command was finished")
+
+// errorDrainTimeout limits the time to wait for the end marker on stderr after the command finished
+// The marker is missing if a command redirected the stderr of the shell, like "exec 2>/dev/null".
+const errorDrainTimeout = time.Second
+
+// errStopped is returned by readSection if reading was stopped because reading the other stream failed
+var errStopped = errors.New("reading the output was stopped")
+
+// ExitError is returned by ExecuteCommand if the shell exited before the command was finished, for example
+// because the command was exit or exec. The shell needs to be restarted before executing further commands.
+type ExitError struct {
+	// ExitCode is the exit status of the shell, or -1 if it was terminated by a signal
+	ExitCode int
+}
+
+func (err *ExitError) Error() string {
+	return fmt.Sprintf("the shell exited with exit code %d before the command was finished", err.ExitCode)
+}
+
 // Shell represents the shell process that runs in the background and executes the commands.
+// It implements Executor.
 type Shell struct {
-	cmd    *exec.Cmd
-	stdin  io.WriteCloser
-	stdout io.ReadCloser
+	backend   Backend
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	stdout    *stream
+	stderr    *stream
+	done      chan struct{}
+	terminal  *os.File   // the master side of the pseudo-terminal, if the shell runs on one
+	exited    *ExitError // set when the shell exited unexpectedly
+	usePTY    bool
+	stripANSI bool
 }
 
 // DetectShell returns the path to the selected shell or the content of $SHELL
@@ -39,69 +76,355 @@ func DetectShell(selected string) (string, error) {
 	return selected, nil
 }
 
-// StartShell starts a shell as a background process
-func StartShell(shell string) (Shell, error) {
-	cmd := exec.Command(shell)
+// StartShell starts a shell as a local background process
+// The shell is started in the working directory dir, or in the current directory if dir is empty. The environment
+// of the shell is env, if env is nil, the shell inherits the environment of the calling process.
+func StartShell(shell string, dir string, env []string) (*Shell, error) {
+	return Start(LocalBackend{Shell: shell}, dir, env)
+}
+
+// Start starts a shell in the background using the specified backend
+func Start(backend Backend, dir string, env []string) (*Shell, error) {
+	shell := NewShell(backend)
+	if err := shell.Start(dir, env); err != nil {
+		return nil, err
+	}
+	return shell, nil
+}
+
+// NewShell creates a shell that uses the specified backend, it needs to be started before executing commands
+func NewShell(backend Backend) *Shell {
+	return &Shell{backend: backend}
+}
+
+// NewTerminalShell creates a shell that runs on a pseudo-terminal, it needs to be started before executing commands
+// On a terminal, the output to stdout and stderr cannot be distinguished. If stripANSI is true, ANSI escape
+// sequences like colors are removed from the output.
+func NewTerminalShell(backend Backend, stripANSI bool) *Shell {
+	return &Shell{backend: backend, usePTY: true, stripANSI: stripANSI}
+}
+
+// terminalSetup is executed when a shell is started on a terminal, to make it behave like a non-interactive shell
+const terminalSetup = "PS1=''; PS2=''; PS0=''; PROMPT_COMMAND=''; set +H +o emacs +o vi 2>/dev/null\n"
+
+// Start starts the shell process in the working directory dir with the environment env
+func (shell *Shell) Start(dir string, env []string) error {
+	cmd, err := shell.backend.Command(dir, env)
+	if err != nil {
+		return err
+	}
+	shell.exited = nil
+	if shell.usePTY {
+		return shell.startOnTerminal(cmd)
+	}
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
-		return Shell{}, fmt.Errorf("Unable to set up input stream for shell %s: %v", shell, err)
+		return fmt.Errorf("Unable to set up input stream for shell %s: %v", cmd.Path, err)
 	}
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return Shell{}, fmt.Errorf("Unable to set up output stream for shell %s: %v", shell, err)
+		return fmt.Errorf("Unable to set up output stream for shell %s: %v", cmd.Path, err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("Unable to set up error stream for shell %s: %v", cmd.Path, err)
 	}
 	err = cmd.Start()
 	if err != nil {
-		return Shell{}, fmt.Errorf("Unable to start shell %s: %v", shell, err)
+		return fmt.Errorf("Unable to start shell %s: %v", cmd.Path, err)
+	}
+	shell.cmd = cmd
+	shell.stdin = stdin
+	shell.stdout = newStream()
+	shell.stderr = newStream()
+	shell.done = make(chan struct{})
+	go readLines(stdout, shell.stdout, shell.done)
+	go readLines(stderr, shell.stderr, shell.done)
+	return nil
+}
+
+// startOnTerminal starts the shell process with a pseudo-terminal as its input and output
+func (shell *Shell) startOnTerminal(cmd *exec.Cmd) error {
+	master, slave, err := openTerminal()
+	if err != nil {
+		return err
+	}
+	defer slave.Close() // the shell process holds its own copy
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	// the shell becomes the leader of a new session with the terminal as its controlling terminal
+	setControllingTerminal(cmd)
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		return fmt.Errorf("Unable to start shell %s: %v", cmd.Path, err)
+	}
+	shell.cmd = cmd
+	shell.terminal = master
+	shell.stdin = master
+	shell.stdout = newStream()
+	shell.stderr = nil
+	shell.done = make(chan struct{})
+	go readLines(master, shell.stdout, shell.done)
+	io.WriteString(shell.stdin, terminalSetup)
+	return nil
+}
+
+// Capabilities returns the features supported by the shell
+func (shell *Shell) Capabilities() Capabilities {
+	return Capabilities{Timeout: true, ErrorOutput: !shell.usePTY, Terminal: shell.usePTY}
+}
+
+// stream holds the lines of one output stream of the shell
+type stream struct {
+	lines chan string
+	err   error // the error that ended reading the stream, set before lines is closed
+}
+
+func newStream() *stream {
+	return &stream{lines: make(chan string)}
+}
+
+// readLines reads the output of the shell line by line and hands the lines to ExecuteCommand
+// It runs in the background for the lifetime of the shell, so that reading can be interrupted by a timeout.
+// The done channel is passed explicitly, since the shell may be restarted while an old reader is still running.
+// Lines can be of any length, a carriage return before the newline is removed.
+func readLines(reader io.Reader, output *stream, done <-chan struct{}) {
+	defer close(output.lines)
+	buffered := bufio.NewReader(reader)
+	for {
+		line, err := buffered.ReadString('\n')
+		if len(line) > 0 {
+			line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+			select {
+			case output.lines <- line:
+			case <-done:
+				return
+			}
+		}
+		if err != nil {
+			if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.EIO {
+				return // a pseudo-terminal reports EIO instead of EOF when the shell exits
+			}
+			if err != io.EOF {
+				output.err = err
+			}
+			return
+		}
+	}
+}
+
+// ExecuteCommand runs a command in the shell and returns its output to stdout and stderr and its exit code
+// If timeout is larger than zero and the command does not finish in time, ErrTimeout is returned. If the shell
+// exits before the command is finished, or has exited before, an *ExitError is returned.
+func (shell *Shell) ExecuteCommand(command string, timeout time.Duration) ([]string, []string, int, error) {
+	if shell.exited != nil {
+		return nil, nil, -1, shell.exited
+	}
+	// the markers contain a random nonce, so that they cannot be confused with the output of the command
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, nil, -1, err
+	}
+	beginMarker := fmt.Sprintf("SHELLDOC_BEGIN_%s", nonce)
+	endMarker := fmt.Sprintf("SHELLDOC_END_%s", nonce)
+	instruction := strings.TrimSpace(command)
+	// the command is written on its own lines between the markers, so that multi-line commands like
+	// here-documents are complete, and trailing comments or & do not affect the markers
+	if shell.terminal != nil {
+		// on a terminal, stdout and stderr are the same stream, the markers are only written once
+		io.WriteString(shell.stdin, fmt.Sprintf("echo \"%s\"\n", typed(beginMarker)))
+		io.WriteString(shell.stdin, fmt.Sprintf("%s\necho \"%s $?\"\n", instruction, typed(endMarker)))
+	} else {
+		// the markers are written to both stdout and stderr, so that the error output can be assigned to the command
+		io.WriteString(shell.stdin, fmt.Sprintf("echo \"%s\"; echo \"%s\" >&2\n", typed(beginMarker), typed(beginMarker)))
+		io.WriteString(shell.stdin, fmt.Sprintf("%s\necho \"%s $?\"; echo \"%s\" >&2\n", instruction, typed(endMarker), typed(endMarker)))
+	}
+
+	deadline := make(chan struct{}) // closed when the timeout expires, both streams are read until then
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() { close(deadline) })
+		defer timer.Stop()
+	}
+
+	if shell.terminal != nil {
+		output, trailer, err := readSection(shell.stdout, beginMarker, endMarker, deadline, nil)
+		if err == errShellExited {
+			return output, nil, -1, shell.wait()
+		} else if err != nil {
+			return output, nil, -1, err
+		}
+		rc, err := strconv.Atoi(strings.TrimSpace(trailer))
+		if err != nil {
+			return nil, nil, -1, fmt.Errorf("unable to read exit code for shell command: %v", err)
+		}
+		return shell.terminalOutput(output), nil, rc, nil
+	}
+
+	// stderr is read concurrently, a command that fills the stderr pipe would block before finishing its stdout otherwise
+	type section struct {
+		lines []string
+		err   error
 	}
-	return Shell{cmd, stdin, stdout}, nil
+	stop := make(chan struct{})
+	errorSection := make(chan section, 1)
+	go func() {
+		lines, _, err := readSection(shell.stderr, beginMarker, endMarker, deadline, stop)
+		errorSection <- section{lines, err}
+	}()
+	output, trailer, err := readSection(shell.stdout, beginMarker, endMarker, deadline, nil)
+	var errorOutput section
+	if err != nil {
+		close(stop)
+		errorOutput = <-errorSection // the stream must not be read by two goroutines, wait for the reader in any case
+	} else {
+		// the end marker on stdout is authoritative, the command may have closed or redirected the stderr of the shell
+		select {
+		case errorOutput = <-errorSection:
+		case <-time.After(errorDrainTimeout):
+			close(stop)
+			errorOutput = <-errorSection
+		}
+	}
+	if err == errShellExited {
+		return output, errorOutput.lines, -1, shell.wait()
+	} else if err != nil {
+		return output, errorOutput.lines, -1, err
+	}
+	rc, err := strconv.Atoi(strings.TrimSpace(trailer))
+	if err != nil {
+		return nil, nil, -1, fmt.Errorf("unable to read exit code for shell command: %v", err)
+	}
+	return output, errorOutput.lines, rc, nil
 }
 
-// ExecuteCommand runs a command in the shell and returns its output and exit code
-func (shell *Shell) ExecuteCommand(command string) ([]string, int, error) {
-	const (
-		beginMarker = ">>>>>>>>>>SHELLDOC_MARKER>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>"
-		endMarker   = "<<<<<<<<<<SHELLDOC_MARKER"
-	)
-	instruction := fmt.Sprintf("%s", strings.TrimSpace(command))
-	io.WriteString(shell.stdin, fmt.Sprintf("echo \"%s\"\n", beginMarker))
-	io.WriteString(shell.stdin, fmt.Sprintf("%s; echo \"%s $?\"\n", instruction, endMarker))
+// wait waits for a shell that exited unexpectedly and returns its exit status as an *ExitError
+func (shell *Shell) wait() error {
+	shell.exited = &ExitError{ExitCode: -1}
+	err := shell.cmd.Wait()
+	if err == nil {
+		shell.exited.ExitCode = 0
+	} else if exitErr, ok := err.(*exec.ExitError); ok {
+		shell.exited.ExitCode = exitErr.ExitCode()
+	}
+	return shell.exited
+}
 
-	// read output (TODO: with timeout), watch for markers:
-	beginEx := fmt.Sprintf("^%s$", beginMarker)
-	beginRx := regexp.MustCompile(beginEx)
-	endEx := fmt.Sprintf("^%s (.+)$", endMarker)
-	endRx := regexp.MustCompile(endEx)
+// newNonce returns a random string to make the markers of a command unique
+func newNonce() (string, error) {
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("unable to generate marker: %v", err)
+	}
+	return hex.EncodeToString(nonce), nil
+}
 
+// typed returns the marker as it is typed into the shell, with an empty string inserted into it
+// If the terminal echoes the input, the echoed command is not mistaken for the marker in the output.
+func typed(marker string) string {
+	return marker[:len(marker)/2] + "\"\"" + marker[len(marker)/2:]
+}
+
+// terminalOutput returns the output lines as they would be displayed on a terminal
+// Text overwritten after a carriage return is removed, and optionally ANSI escape sequences.
+func (shell *Shell) terminalOutput(output []string) []string {
+	var result []string
+	for _, line := range output {
+		line = strings.TrimRight(line, "\r")
+		line = line[strings.LastIndex(line, "\r")+1:]
+		if shell.stripANSI {
+			line = StripANSI(line)
+		}
+		result = append(result, line)
+	}
+	return result
+}
+
+// readSection returns the lines received between the begin and the end marker, and the rest of the line after the end marker
+// The markers do not need to be at the beginning of a line, since the output of a command may not end in a newline.
+// If the shell stops sending output before the end marker, the lines received so far are returned with errShellExited,
+// or with the error that ended reading the output. Reading ends with ErrTimeout when deadline is closed, and with
+// errStopped when stop is closed.
+func readSection(input *stream, beginMarker, endMarker string, deadline, stop <-chan struct{}) ([]string, string, error) {
 	var output []string
-	var rc int
 	beginFound := false
-	scanner := bufio.NewScanner(shell.stdout)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if beginRx.MatchString(line) {
-			beginFound = true
-			continue
+	for {
+		var line string
+		select {
+		case received, ok := <-input.lines:
+			if !ok {
+				if input.err != nil {
+					return output, "", fmt.Errorf("unable to read the output of the shell: %v", input.err)
+				}
+				return output, "", errShellExited
+			}
+			line = received
+		case <-deadline:
+			return output, "", ErrTimeout
+		case <-stop:
+			return output, "", errStopped
 		}
-		if beginFound == false {
+		if !beginFound {
+			beginFound = strings.Contains(line, beginMarker)
 			continue
 		}
-		match := endRx.FindStringSubmatch(line)
-		if len(match) > 1 {
-			value, err := strconv.Atoi(match[1])
-			if err != nil {
-				return nil, -1, fmt.Errorf("unable to read exit code for shell command: %v", err)
+		if index := strings.Index(line, endMarker); index >= 0 {
+			if index > 0 {
+				output = append(output, line[:index])
 			}
-			rc = value
-			break
+			return output, line[index+len(endMarker):], nil
 		}
 		output = append(output, line)
 	}
-	return output, rc, nil
 }
 
 // Exit tells a running shell to exit and waits for it
+// Exit does nothing if the shell is not running, for example after Kill and a failed restart.
 func (shell *Shell) Exit() error {
+	if !shell.stop() {
+		return nil
+	}
+	defer shell.closeTerminal()
+	if shell.exited != nil {
+		return nil // the shell has exited already, this was reported by ExecuteCommand
+	}
 	io.WriteString(shell.stdin, "exit\n")
 	return shell.cmd.Wait()
 }
+
+// stop ends the readers of the current shell process, it returns false if the shell was not started or is stopped already
+func (shell *Shell) stop() bool {
+	if shell.done == nil {
+		return false
+	}
+	select {
+	case <-shell.done:
+		return false
+	default:
+		close(shell.done)
+		return true
+	}
+}
+
+// closeTerminal closes the master side of the pseudo-terminal after the shell has finished
+func (shell *Shell) closeTerminal() {
+	if shell.terminal != nil {
+		shell.terminal.Close()
+	}
+}
+
+// Kill terminates the shell and all processes started from it and waits for it
+// It is used to get rid of a shell that is not responding anymore, for example after a timeout.
+// Like Exit, it does nothing if the shell is not running.
+func (shell *Shell) Kill() error {
+	if !shell.stop() {
+		return nil
+	}
+	defer shell.closeTerminal()
+	if shell.exited != nil {
+		return nil // the shell has exited already, this was reported by ExecuteCommand
+	}
+	if err := shell.backend.Kill(shell.cmd); err != nil {
+		return err
+	}
+	shell.cmd.Wait() // the shell has been killed, an error is expected here
+	return nil
+}
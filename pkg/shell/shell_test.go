@@ -6,8 +6,14 @@ package shell
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -20,7 +26,7 @@ func TestMain(m *testing.M) {
 }
 func TestShellLifeCycle(t *testing.T) {
 	// The most basic test, start a shell and exit it again
-	shell, err := StartShell(shellpath)
+	shell, err := StartShell(shellpath, "", nil)
 	require.NoError(t, err, "Starting a shell should work")
 	require.NoError(t, shell.Exit(), "Exiting ad running shell should work")
 }
@@ -28,7 +34,7 @@ func TestShellLifeCycle(t *testing.T) {
 func TestShellLifeCycleRepeated(t *testing.T) {
 	// Can the program start and stop a shell repeatedly?
 	for counter := 0; counter < 16; counter++ {
-		shell, err := StartShell(shellpath)
+		shell, err := StartShell(shellpath, "", nil)
 		require.NoError(t, err, "Starting a shell should work")
 		require.NoError(t, shell.Exit(), "Exiting ad running shell should work")
 	}
@@ -36,17 +42,17 @@ func TestShellLifeCycleRepeated(t *testing.T) {
 
 func TestReturnCodes(t *testing.T) {
 	// Does the shell report return codes corrrectly?
-	shell, err := StartShell(shellpath)
+	shell, err := StartShell(shellpath, "", nil)
 	require.NoError(t, err, "Starting a shell should work")
 	defer shell.Exit()
 	{
-		output, rc, err := shell.ExecuteCommand("true")
+		output, _, rc, err := shell.ExecuteCommand("true", 0)
 		require.NoError(t, err, "The true command is a builtin and should always work")
 		require.Equal(t, 0, rc, "The exit code of true should always be zero")
 		require.Empty(t, output, "true does not say a word")
 	}
 	{
-		output, rc, err := shell.ExecuteCommand("false")
+		output, _, rc, err := shell.ExecuteCommand("false", 0)
 		require.NoError(t, err, "The false command is a builtin and should always work")
 		require.NotEqual(t, 0, rc, "The exit code of false should never be zero")
 		require.Empty(t, output, "false does not say a word")
@@ -55,7 +61,7 @@ func TestReturnCodes(t *testing.T) {
 
 func TestCaptureOutput(t *testing.T) {
 	// Does the shell capture and return the lines printed by the command correctly?
-	shell, err := StartShell(shellpath)
+	shell, err := StartShell(shellpath, "", nil)
 	require.NoError(t, err, "Starting a shell should work")
 	defer shell.Exit()
 	{
@@ -63,7 +69,7 @@ func TestCaptureOutput(t *testing.T) {
 			hello = "Hello"
 			world = "World"
 		)
-		output, rc, err := shell.ExecuteCommand(fmt.Sprintf("echo %s && echo %s", hello, world))
+		output, _, rc, err := shell.ExecuteCommand(fmt.Sprintf("echo %s && echo %s", hello, world), 0)
 		require.NoError(t, err, "The echo command is a builtin and should always work")
 		require.Equal(t, 0, rc, "The exit code of echo should be zero")
 		require.Len(t, output, 2, "echo was called twice")
@@ -71,3 +77,332 @@ func TestCaptureOutput(t *testing.T) {
 		require.Equal(t, output[1], world, "actually, two")
 	}
 }
+
+func TestCaptureErrorOutput(t *testing.T) {
+	// Does the shell capture the output to stderr separately from the output to stdout?
+	shell, err := StartShell(shellpath, "", nil)
+	require.NoError(t, err, "Starting a shell should work")
+	defer shell.Exit()
+	for counter := 0; counter < 2; counter++ {
+		output, errorOutput, rc, err := shell.ExecuteCommand("echo Hello && echo World >&2", 0)
+		require.NoError(t, err, "The echo command is a builtin and should always work")
+		require.Equal(t, 0, rc, "The exit code of echo should be zero")
+		require.Equal(t, []string{"Hello"}, output, "Hello was written to stdout")
+		require.Equal(t, []string{"World"}, errorOutput, "World was written to stderr")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	// Does the shell give up on a command that takes too long?
+	shell, err := StartShell(shellpath, "", nil)
+	require.NoError(t, err, "Starting a shell should work")
+	_, _, _, err = shell.ExecuteCommand("sleep 10", 100*time.Millisecond)
+	require.Equal(t, ErrTimeout, err, "sleep 10 should not finish within 100ms")
+	require.NoError(t, shell.Kill(), "Killing a hanging shell should work")
+}
+
+func TestWorkingDirectoryAndEnvironment(t *testing.T) {
+	// Does the shell start in the specified directory with the specified environment?
+	dir, err := ioutil.TempDir("", "shell_test-")
+	require.NoError(t, err, "Unable to create temporary directory")
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	require.NoError(t, err, "Unable to resolve temporary directory")
+	shell, err := StartShell(shellpath, dir, []string{"GREETING=Hello"})
+	require.NoError(t, err, "Starting a shell should work")
+	defer shell.Exit()
+	output, _, rc, err := shell.ExecuteCommand("pwd -P && echo $GREETING", 0)
+	require.NoError(t, err, "pwd and echo are builtins and should always work")
+	require.Equal(t, 0, rc, "The exit code of pwd and echo should be zero")
+	require.Equal(t, []string{dir, "Hello"}, output, "The shell runs in the directory with the environment")
+}
+
+func TestContainerBackend(t *testing.T) {
+	// Does the container backend start the shell through the container runtime?
+	dir, err := ioutil.TempDir("", "shell_test-")
+	require.NoError(t, err, "Unable to create temporary directory")
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	require.NoError(t, err, "Unable to resolve temporary directory")
+	// the fake runtime interprets the arguments of "run" that matter for the test and starts the shell locally
+	runtime := filepath.Join(dir, "fake-runtime")
+	script := `#!/bin/sh
+[ "$1" = rm ] && exit 0
+shift
+while [ $# -gt 2 ]; do
+	case "$1" in
+	--workdir) cd "$2"; shift;;
+	--env) export "$2"; shift;;
+	--name|--volume) shift;;
+	esac
+	shift
+done
+exec "$2"
+`
+	require.NoError(t, ioutil.WriteFile(runtime, []byte(script), 0755), "Unable to write fake container runtime")
+	docs := filepath.Join(dir, "docs")
+	require.NoError(t, os.Mkdir(docs, 0755), "Unable to create documentation directory")
+	backend := ContainerBackend{Runtime: runtime, Image: "example", Mount: docs}
+	cmd, err := backend.Command("", []string{"GREETING=Hello"})
+	require.NoError(t, err, "Creating the container command should work")
+	require.Equal(t, []string{runtime, "run", "--interactive", "--rm", "--name", containerName(cmd), "--volume", docs + ":" + docs,
+		"--workdir", docs, "--env", "GREETING=Hello", "example", DefaultContainerShell}, cmd.Args, "The shell is started in the container")
+	cmd, err = backend.Command(dir, nil)
+	require.NoError(t, err, "Creating the container command should work")
+	require.Equal(t, []string{runtime, "run", "--interactive", "--rm", "--name", containerName(cmd), "--volume", docs + ":" + docs,
+		"--volume", dir + ":" + dir, "--workdir", dir, "example", DefaultContainerShell}, cmd.Args, "The working directory is mounted as well")
+
+	shell, err := Start(backend, "", []string{"GREETING=Hello"})
+	require.NoError(t, err, "Starting a shell in a container should work")
+	output, _, rc, err := shell.ExecuteCommand("pwd -P && echo $GREETING", 0)
+	require.NoError(t, err, "pwd and echo are builtins and should always work")
+	require.Equal(t, 0, rc, "The exit code of pwd and echo should be zero")
+	require.Equal(t, []string{docs, "Hello"}, output, "The shell runs in the mounted directory with the environment")
+	require.NoError(t, shell.Kill(), "Killing a shell in a container should work")
+}
+
+func TestRestart(t *testing.T) {
+	// Can a shell be restarted after it has been killed?
+	var executor Executor = NewShell(LocalBackend{Shell: shellpath})
+	require.NoError(t, executor.Start("", nil), "Starting a shell should work")
+	_, _, _, err := executor.ExecuteCommand("sleep 10", 100*time.Millisecond)
+	require.Equal(t, ErrTimeout, err, "sleep 10 should not finish within 100ms")
+	require.NoError(t, executor.Kill(), "Killing a hanging shell should work")
+	require.NoError(t, executor.Start("", nil), "Restarting the shell should work")
+	output, _, rc, err := executor.ExecuteCommand("echo Hello", 0)
+	require.NoError(t, err, "The restarted shell should execute commands")
+	require.Equal(t, 0, rc, "The exit code of echo should be zero")
+	require.Equal(t, []string{"Hello"}, output, "The restarted shell only returns the output of the new command")
+	require.NoError(t, executor.Exit(), "Exiting a restarted shell should work")
+}
+
+func TestExitAfterKill(t *testing.T) {
+	// Can a shell that is not running be exited, for example after a restart failed?
+	dir, err := ioutil.TempDir("", "shell_test-")
+	require.NoError(t, err, "Unable to create temporary directory")
+	defer os.RemoveAll(dir)
+	var executor Executor = NewShell(LocalBackend{Shell: shellpath})
+	require.NoError(t, executor.Exit(), "Exiting a shell that was never started does nothing")
+	require.NoError(t, executor.Start(dir, nil), "Starting a shell should work")
+	require.NoError(t, executor.Kill(), "Killing a shell should work")
+	require.Error(t, executor.Start(filepath.Join(dir, "missing"), nil), "The shell cannot be restarted in a missing directory")
+	require.NoError(t, executor.Exit(), "Exiting a killed shell does nothing")
+	require.NoError(t, executor.Kill(), "Killing a killed shell does nothing")
+}
+
+func TestSSHBackend(t *testing.T) {
+	// Does the SSH backend start the shell through the ssh client?
+	dir, err := ioutil.TempDir("", "shell_test-")
+	require.NoError(t, err, "Unable to create temporary directory")
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	require.NoError(t, err, "Unable to resolve temporary directory")
+	// the fake client executes the remote command locally, like the login shell on the remote host would
+	client := filepath.Join(dir, "fake-ssh")
+	script := `#!/bin/sh
+while [ "$1" != "--" ]; do shift; done
+exec sh -c "$3"
+`
+	require.NoError(t, ioutil.WriteFile(client, []byte(script), 0755), "Unable to write fake ssh client")
+	backend := SSHBackend{Client: client, Destination: "user@example.com", Options: []string{"Port=2222"}}
+	cmd, err := backend.Command(dir, []string{"GREETING=Hello 'World'"})
+	require.NoError(t, err, "Creating the ssh command should work")
+	require.Equal(t, []string{client, "-T", "-o", "BatchMode=yes", "-o", "Port=2222", "--", "user@example.com",
+		"cd '" + dir + "' && exec env 'GREETING=Hello '\\''World'\\''' '/bin/sh'"}, cmd.Args, "The shell is started on the remote host")
+
+	shell, err := Start(backend, dir, []string{"GREETING=Hello 'World'"})
+	require.NoError(t, err, "Starting a shell on a remote host should work")
+	output, _, rc, err := shell.ExecuteCommand("pwd -P && echo $GREETING", 0)
+	require.NoError(t, err, "pwd and echo are builtins and should always work")
+	require.Equal(t, 0, rc, "The exit code of pwd and echo should be zero")
+	require.Equal(t, []string{dir, "Hello 'World'"}, output, "The shell runs in the directory with the environment")
+	require.NoError(t, shell.Exit(), "Exiting a shell on a remote host should work")
+}
+
+func TestSSHLocalhost(t *testing.T) {
+	// Does the SSH backend work with a real sshd? The test starts a throwaway sshd on localhost.
+	sshd, err := exec.LookPath("sshd")
+	if err != nil {
+		if sshd, err = exec.LookPath("/usr/sbin/sshd"); err != nil {
+			t.Skip("sshd is not installed")
+		}
+	}
+	client, err := exec.LookPath("ssh")
+	if err != nil {
+		t.Skip("the ssh client is not installed")
+	}
+	user, err := user.Current()
+	require.NoError(t, err, "Unable to determine the current user")
+	dir, err := ioutil.TempDir("", "shell_test-")
+	require.NoError(t, err, "Unable to create temporary directory")
+	defer os.RemoveAll(dir)
+	for _, key := range []string{"host_key", "user_key"} {
+		output, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", filepath.Join(dir, key)).CombinedOutput()
+		require.NoError(t, err, "Unable to generate key: %s", output)
+	}
+	publicKey, err := ioutil.ReadFile(filepath.Join(dir, "user_key.pub"))
+	require.NoError(t, err, "Unable to read public key")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "authorized_keys"), publicKey, 0600), "Unable to write authorized keys")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Unable to find a free port")
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	config := fmt.Sprintf("Port %d\nListenAddress 127.0.0.1\nHostKey %s\nAuthorizedKeysFile %s\nPidFile %s\nStrictModes no\nUsePAM no\n",
+		port, filepath.Join(dir, "host_key"), filepath.Join(dir, "authorized_keys"), filepath.Join(dir, "sshd.pid"))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "sshd_config"), []byte(config), 0600), "Unable to write sshd configuration")
+	daemon := exec.Command(sshd, "-D", "-e", "-f", filepath.Join(dir, "sshd_config"))
+	require.NoError(t, daemon.Start(), "Unable to start sshd")
+	defer daemon.Wait()
+	defer daemon.Process.Kill()
+	for attempt := 0; attempt < 50; attempt++ {
+		if connection, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port)); err == nil {
+			connection.Close()
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	backend := SSHBackend{Client: client, Destination: fmt.Sprintf("%s@127.0.0.1", user.Username), Options: []string{
+		fmt.Sprintf("Port=%d", port), "IdentityFile=" + filepath.Join(dir, "user_key"), "IdentitiesOnly=yes",
+		"StrictHostKeyChecking=no", "UserKnownHostsFile=/dev/null", "LogLevel=ERROR"}}
+	shell, err := Start(backend, dir, []string{"GREETING=Hello"})
+	require.NoError(t, err, "Starting a shell on localhost should work")
+	output, _, rc, err := shell.ExecuteCommand("echo $GREETING && (exit 3)", 10*time.Second)
+	require.NoError(t, err, "The remote shell should execute commands")
+	require.Equal(t, 3, rc, "The exit code is transferred from the remote host")
+	require.Equal(t, []string{"Hello"}, output, "The output is transferred from the remote host")
+	require.NoError(t, shell.Exit(), "Exiting a shell on localhost should work")
+}
+
+func TestTerminal(t *testing.T) {
+	// Does the shell run commands on a pseudo-terminal?
+	shell := NewTerminalShell(LocalBackend{Shell: shellpath}, true)
+	require.NoError(t, shell.Start("", nil), "Starting a shell on a terminal should work")
+	defer shell.Exit()
+	require.Equal(t, Capabilities{Timeout: true, Terminal: true}, shell.Capabilities(), "Error output is not captured on a terminal")
+	commands := []struct {
+		command string
+		output  []string
+		rc      int
+	}{
+		{"test -t 0 && test -t 1 && echo terminal", []string{"terminal"}, 0},
+		{"printf '\\033[1;31mHello\\033[0m World\\n'", []string{"Hello World"}, 0},
+		{"echo Hello >&2; echo 'World!'", []string{"Hello", "World!"}, 0},
+		{"printf 'Loading...\\rDone      \\n'", []string{"Done      "}, 0},
+		{"cat <<EOF\nHello\nEOF\n(exit 3)", []string{"Hello"}, 3},
+	}
+	for _, command := range commands {
+		output, errorOutput, rc, err := shell.ExecuteCommand(command.command, 5*time.Second)
+		require.NoError(t, err, "The command should execute on the terminal: %s", command.command)
+		require.Equal(t, command.rc, rc, "The exit code is reported: %s", command.command)
+		require.Equal(t, command.output, output, "The terminal output is returned: %s", command.command)
+		require.Empty(t, errorOutput, "There is no separate error output on a terminal")
+	}
+}
+
+func TestStripANSI(t *testing.T) {
+	require.Equal(t, "Hello World", StripANSI("\x1b[1;31mHello\x1b[0m World"), "Colors are removed")
+	require.Equal(t, "Hello", StripANSI("\x1b]0;title\x07Hello"), "Operating system commands are removed")
+	require.Equal(t, "Hello", StripANSI("\x1bMHello"), "Two-character escape sequences are removed")
+}
+
+func TestFraming(t *testing.T) {
+	// Are the commands framed correctly, even if their output is unusual?
+	shell, err := StartShell(shellpath, "", nil)
+	require.NoError(t, err, "Starting a shell should work")
+	defer shell.Exit()
+	commands := []struct {
+		command     string
+		output      []string
+		errorOutput []string
+		rc          int
+	}{
+		{"printf Hello; printf World >&2", []string{"Hello"}, []string{"World"}, 0},
+		{"echo Hello # a trailing comment", []string{"Hello"}, nil, 0},
+		{"true &", nil, nil, 0},
+		{"echo SHELLDOC_BEGIN_ SHELLDOC_END_ 1", []string{"SHELLDOC_BEGIN_ SHELLDOC_END_ 1"}, nil, 0},
+		{"printf 'Hello\\n\\nWorld'; false", []string{"Hello", "", "World"}, nil, 1},
+	}
+	for _, command := range commands {
+		output, errorOutput, rc, err := shell.ExecuteCommand(command.command, 5*time.Second)
+		require.NoError(t, err, "The command should be executed: %s", command.command)
+		require.Equal(t, command.rc, rc, "The exit code is reported: %s", command.command)
+		require.Equal(t, command.output, output, "The output is returned: %s", command.command)
+		require.Equal(t, command.errorOutput, errorOutput, "The error output is returned: %s", command.command)
+	}
+}
+
+func TestShellExited(t *testing.T) {
+	// Is it reported if the shell exits while executing a command?
+	shell, err := StartShell(shellpath, "", nil)
+	require.NoError(t, err, "Starting a shell should work")
+	output, _, _, err := shell.ExecuteCommand("echo Goodbye; exit 3", 5*time.Second)
+	require.Equal(t, &ExitError{ExitCode: 3}, err, "The shell exited before the end marker")
+	require.Equal(t, []string{"Goodbye"}, output, "The output before the exit is returned")
+	_, _, _, err = shell.ExecuteCommand("echo Hello", 5*time.Second)
+	require.Equal(t, &ExitError{ExitCode: 3}, err, "The shell does not execute commands after it exited")
+	require.NoError(t, shell.Start("", nil), "The shell can be restarted after it exited")
+	output, _, _, err = shell.ExecuteCommand("echo Hello", 5*time.Second)
+	require.NoError(t, err, "The restarted shell executes commands")
+	require.Equal(t, []string{"Hello"}, output, "The restarted shell returns the output")
+	require.NoError(t, shell.Exit(), "The restarted shell exits")
+}
+
+func TestLongAndBinaryOutput(t *testing.T) {
+	// Are lines of any length and binary output returned unchanged?
+	shell, err := StartShell(shellpath, "", nil)
+	require.NoError(t, err, "Starting a shell should work")
+	defer shell.Exit()
+	output, _, rc, err := shell.ExecuteCommand("head -c 1000000 /dev/zero | tr '\\0' a; echo", 5*time.Second)
+	require.NoError(t, err, "Long lines should be read")
+	require.Equal(t, 0, rc, "The exit code should be zero")
+	require.Len(t, output, 1, "The output is one line")
+	require.Len(t, output[0], 1000000, "The line is returned completely")
+	output, _, rc, err = shell.ExecuteCommand("printf 'a\\000b\\377\\r\\n'", 5*time.Second)
+	require.NoError(t, err, "Binary output should be read")
+	require.Equal(t, 0, rc, "The exit code should be zero")
+	require.Equal(t, []string{"a\x00b\xff"}, output, "Binary output is returned unchanged")
+}
+
+func TestLargeErrorOutput(t *testing.T) {
+	// Does a command finish that writes more to stderr than the pipe can hold, before writing to stdout?
+	shell, err := StartShell(shellpath, "", nil)
+	require.NoError(t, err, "Starting a shell should work")
+	defer shell.Exit()
+	output, errorOutput, rc, err := shell.ExecuteCommand("for i in $(seq 1 10000); do echo \"error line $i of the output\" >&2; done; echo done", 10*time.Second)
+	require.NoError(t, err, "The command should not block on a full stderr pipe")
+	require.Equal(t, 0, rc, "The exit code should be zero")
+	require.Equal(t, []string{"done"}, output, "The output to stdout is returned")
+	require.Len(t, errorOutput, 10000, "The output to stderr is returned completely")
+	output, _, _, err = shell.ExecuteCommand("echo next", 5*time.Second)
+	require.NoError(t, err, "The shell should still work")
+	require.Equal(t, []string{"next"}, output)
+}
+
+func TestRedirectedErrorOutput(t *testing.T) {
+	// Does a command finish without a timeout after the stderr of the shell was redirected or closed?
+	shell, err := StartShell(shellpath, "", nil)
+	require.NoError(t, err, "Starting a shell should work")
+	defer shell.Kill()
+	finished := make(chan error, 1)
+	go func() {
+		for _, command := range []string{"exec 3>&2 2>/dev/null", "echo Hello >&2; echo World", "exec 2>&3 3>&-", "exec 2>&-", "echo done"} {
+			if _, _, _, err := shell.ExecuteCommand(command, 0); err != nil {
+				finished <- err
+				return
+			}
+		}
+		finished <- nil
+	}()
+	select {
+	case err := <-finished:
+		require.NoError(t, err, "The commands should be executed")
+	case <-time.After(20 * time.Second):
+		require.Fail(t, "The commands should not wait for the end marker on stderr forever")
+	}
+}
+
+func TestQuote(t *testing.T) {
+	require.Equal(t, `'echo Hello'`, Quote("echo Hello"))
+	require.Equal(t, `'echo '\''Hello'\'''`, Quote("echo 'Hello'"), "Single quotes are escaped")
+}
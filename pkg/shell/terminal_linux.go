@@ -0,0 +1,67 @@
+//go:build linux
+// +build linux
+
+package shell
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// openTerminal opens a new pseudo-terminal and returns its master and slave side
+// The terminal does not echo the input and does not translate newlines, so that the output resembles a pipe.
+func openTerminal() (*os.File, *os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to open pseudo-terminal: %v", err)
+	}
+	var unlock int32
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("unable to unlock pseudo-terminal: %v", err)
+	}
+	var number uint32
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&number)); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("unable to determine pseudo-terminal number: %v", err)
+	}
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", number), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("unable to open pseudo-terminal: %v", err)
+	}
+	var termios syscall.Termios
+	if err := ioctl(slave, syscall.TCGETS, unsafe.Pointer(&termios)); err != nil {
+		master.Close()
+		slave.Close()
+		return nil, nil, fmt.Errorf("unable to read pseudo-terminal attributes: %v", err)
+	}
+	termios.Lflag &^= syscall.ECHO
+	termios.Oflag &^= syscall.ONLCR
+	if err := ioctl(slave, syscall.TCSETS, unsafe.Pointer(&termios)); err != nil {
+		master.Close()
+		slave.Close()
+		return nil, nil, fmt.Errorf("unable to set pseudo-terminal attributes: %v", err)
+	}
+	// some programs do not work on terminals without a size
+	size := struct{ rows, columns, x, y uint16 }{24, 80, 0, 0}
+	if err := ioctl(slave, syscall.TIOCSWINSZ, unsafe.Pointer(&size)); err != nil {
+		master.Close()
+		slave.Close()
+		return nil, nil, fmt.Errorf("unable to set pseudo-terminal size: %v", err)
+	}
+	return master, slave, nil
+}
+
+func ioctl(file *os.File, request uintptr, argument unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), request, uintptr(argument)); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package shell
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"os"
+)
+
+// openTerminal is only implemented on Linux
+func openTerminal() (*os.File, *os.File, error) {
+	return nil, nil, fmt.Errorf("pseudo-terminals are not supported on this platform")
+}
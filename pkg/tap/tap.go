@@ -0,0 +1,135 @@
+// Package tap writes test results in the Test Anything Protocol (TAP) format, version 13.
+// See https://testanything.org/tap-version-13-specification.html for the specification.
+package tap
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Directives that can be attached to a test point
+const (
+	// Skip marks a test point that was not executed
+	Skip = "SKIP"
+	// Todo marks a test point that is known to fail
+	Todo = "TODO"
+)
+
+// Report contains the test points of a test run.
+type Report struct {
+	Points []Point
+}
+
+// Point is a single test point with its result.
+type Point struct {
+	// OK is true if the test passed
+	OK bool
+	// Description describes the test
+	Description string
+	// Directive is Skip, Todo or empty
+	Directive string
+	// Explanation contains the reason for the directive
+	Explanation string
+	// Comment is written as a diagnostic line before the test point, for example to name the file of the test
+	Comment string
+	// Diagnostics are written as a YAML block after the test point
+	Diagnostics []Diagnostic
+}
+
+// Diagnostic is an entry of the YAML diagnostic block of a test point.
+// The value is either a string, a list of strings or an integer.
+type Diagnostic struct {
+	Key   string
+	Value interface{}
+}
+
+// AddPoint adds a test point to the report.
+func (report *Report) AddPoint(point Point) {
+	report.Points = append(report.Points, point)
+}
+
+// Write writes the report in TAP version 13 format.
+func (report Report) Write(w io.Writer) error {
+	var builder strings.Builder
+	builder.WriteString("TAP version 13\n")
+	fmt.Fprintf(&builder, "1..%d\n", len(report.Points))
+	for index, point := range report.Points {
+		if len(point.Comment) > 0 {
+			for _, line := range strings.Split(point.Comment, "\n") {
+				fmt.Fprintf(&builder, "# %s\n", line)
+			}
+		}
+		status := "ok"
+		if !point.OK {
+			status = "not ok"
+		}
+		fmt.Fprintf(&builder, "%s %d", status, index+1)
+		if len(point.Description) > 0 {
+			fmt.Fprintf(&builder, " - %s", escape(point.Description))
+		}
+		if len(point.Directive) > 0 {
+			fmt.Fprintf(&builder, " # %s", point.Directive)
+			if len(point.Explanation) > 0 {
+				fmt.Fprintf(&builder, " %s", escape(point.Explanation))
+			}
+		}
+		builder.WriteString("\n")
+		if len(point.Diagnostics) > 0 {
+			if err := writeDiagnostics(&builder, point.Diagnostics); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+// writeDiagnostics writes the YAML block of a test point
+// Strings are written as double-quoted scalars, which use the JSON escape sequences.
+func writeDiagnostics(builder *strings.Builder, diagnostics []Diagnostic) error {
+	builder.WriteString("  ---\n")
+	for _, diagnostic := range diagnostics {
+		switch value := diagnostic.Value.(type) {
+		case []string:
+			if len(value) == 0 {
+				fmt.Fprintf(builder, "  %s: []\n", diagnostic.Key)
+				continue
+			}
+			fmt.Fprintf(builder, "  %s:\n", diagnostic.Key)
+			for _, line := range value {
+				fmt.Fprintf(builder, "    - %s\n", quote(line))
+			}
+		case string:
+			fmt.Fprintf(builder, "  %s: %s\n", diagnostic.Key, quote(value))
+		case int:
+			fmt.Fprintf(builder, "  %s: %d\n", diagnostic.Key, value)
+		default:
+			return fmt.Errorf("unsupported value for TAP diagnostic %s: %v", diagnostic.Key, value)
+		}
+	}
+	builder.WriteString("  ...\n")
+	return nil
+}
+
+// quote returns text as a double-quoted YAML scalar
+func quote(text string) string {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(text) // encoding a string does not fail
+	return strings.TrimSuffix(buffer.String(), "\n")
+}
+
+// escape makes text usable as the description of a test point, which ends at the first unescaped # or newline
+func escape(text string) string {
+	text = strings.Replace(text, "\\", "\\\\", -1)
+	text = strings.Replace(text, "#", "\\#", -1)
+	return strings.Replace(text, "\n", " ", -1)
+}
@@ -0,0 +1,53 @@
+package tap
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmptyReport(t *testing.T) {
+	var buffer bytes.Buffer
+	require.NoError(t, Report{}.Write(&buffer), "Writing an empty report should work")
+	require.Equal(t, "TAP version 13\n1..0\n", buffer.String(), "An empty report has an empty plan")
+}
+
+func TestReport(t *testing.T) {
+	var report Report
+	report.AddPoint(Point{OK: true, Description: "README.md:5 echo Hello # greet", Comment: "README.md"})
+	report.AddPoint(Point{OK: true, Description: "README.md:9 apt-get install shelldoc", Directive: Skip, Explanation: "root privileges required"})
+	report.AddPoint(Point{OK: false, Description: "README.md:12 echo World", Diagnostics: []Diagnostic{
+		{Key: "command", Value: "echo World"},
+		{Key: "expected", Value: []string{"Hello \"World\""}},
+		{Key: "actual", Value: []string(nil)},
+		{Key: "exitcode", Value: 0},
+	}})
+	report.AddPoint(Point{OK: false, Description: "README.md:15 false", Directive: Todo, Explanation: "known to be broken"})
+	var buffer bytes.Buffer
+	require.NoError(t, report.Write(&buffer), "Writing the report should work")
+	require.Equal(t, `TAP version 13
+1..4
+# README.md
+ok 1 - README.md:5 echo Hello \# greet
+ok 2 - README.md:9 apt-get install shelldoc # SKIP root privileges required
+not ok 3 - README.md:12 echo World
+  ---
+  command: "echo World"
+  expected:
+    - "Hello \"World\""
+  actual: []
+  exitcode: 0
+  ...
+not ok 4 - README.md:15 false # TODO known to be broken
+`, buffer.String(), "The report is written in TAP format")
+}
+
+func TestUnsupportedDiagnostic(t *testing.T) {
+	report := Report{Points: []Point{{OK: false, Diagnostics: []Diagnostic{{Key: "time", Value: 1.5}}}}}
+	require.Error(t, report.Write(&bytes.Buffer{}), "Unsupported diagnostic values are reported")
+}
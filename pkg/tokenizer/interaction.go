@@ -7,9 +7,12 @@ package tokenizer
 import (
 	"fmt"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/endocode/shelldoc/pkg/diff"
 	"github.com/endocode/shelldoc/pkg/shell"
 )
 
@@ -26,6 +29,53 @@ const (
 	ResultRegexMatch
 	// ResultMismatch indicates that the output from the command did not match expectations in any way
 	ResultMismatch
+	// ResultTimeout indicates that the command did not finish within the configured timeout
+	ResultTimeout
+	// ResultSkipped indicates that the interaction was not executed on purpose
+	ResultSkipped
+	// ResultExpectedFailure indicates that an interaction marked as known to be broken failed as expected
+	ResultExpectedFailure
+	// ResultUnexpectedSuccess indicates that an interaction marked as known to be broken succeeded
+	ResultUnexpectedSuccess
+	// ResultShellExited indicates that the shell exited while executing the command, for example because of exit or exec
+	ResultShellExited
+)
+
+const (
+	// ExitCodeOption specifies the exit code the command is expected to return
+	ExitCodeOption = "shelldocexitcode"
+	// ExitCodeWhateverOption specifies that the exit code of the command does not matter
+	ExitCodeWhateverOption = "shelldocwhatever"
+	// TimeoutOption specifies how long the command may run before it is considered hanging (for example, "30s")
+	TimeoutOption = "shelldoctimeout"
+	// StreamOption specifies which output of the command is compared to the expected response
+	StreamOption = "shelldocstream"
+	// RegexOption specifies that all lines of the expected response are regular expressions
+	RegexOption = "shelldocregex"
+	// PromptOption specifies a comma-separated list of prompts that mark commands in the code block
+	PromptOption = "shelldocprompt"
+	// RootPromptOption specifies a comma-separated list of prompts that mark commands requiring root privileges
+	RootPromptOption = "shelldocrootprompt"
+	// SkipOption specifies that the commands in the code block are not executed
+	SkipOption = "shelldocskip"
+	// OnlyOption specifies that only the commands in code blocks with this option are executed
+	OnlyOption = "shelldoconly"
+	// ExpectedFailureOption marks the commands in the code block as known to be broken
+	ExpectedFailureOption = "shelldocxfail"
+	// TerminalOption specifies that the commands in the code block are executed on a pseudo-terminal
+	TerminalOption = "shelldocpty"
+)
+
+// RegexMarker marks an individual line of the expected response as a regular expression, like in "Hello .* (re)"
+const RegexMarker = " (re)"
+
+const (
+	// StreamStdout compares the output to stdout with the expected response (the default)
+	StreamStdout = "stdout"
+	// StreamStderr compares the output to stderr with the expected response
+	StreamStderr = "stderr"
+	// StreamMerged compares the output to stdout and stderr, in the order it was written, with the expected response
+	StreamMerged = "merged"
 )
 
 // Interaction represents one interaction with the shell
@@ -34,7 +84,6 @@ type Interaction struct {
 	Cmd string
 	// Response contains the expected response from the shell, in plain text
 	Response []string
-	//AlternativeRegEx string
 	// Language contains the language specified if the interaction was extracted from a fenced code block
 	Language string
 	// Attributes contains the shelldoc attributes specified in a fenced code block
@@ -47,6 +96,22 @@ type Interaction struct {
 	Comment string
 	// Output contains the output of the interaction after it has been executed as individual lines
 	Output []string
+	// ErrorOutput contains the output of the interaction to stderr after it has been executed as individual lines
+	ErrorOutput []string
+	// ExitCode contains the exit code of the command after it has been executed
+	ExitCode int
+	// Duration contains the time it took to execute the command
+	Duration time.Duration
+	// RootRequired is true if the command was marked with a root prompt
+	RootRequired bool
+	// UseSudo requests the command to be executed with root privileges using sudo
+	UseSudo bool
+	// Filename contains the path of the Markdown file the interaction was read from
+	Filename string
+	// CommandSpan contains the position of the command in the Markdown source
+	CommandSpan Span
+	// ResponseSpan contains the position of the expected response in the Markdown source
+	ResponseSpan Span
 }
 
 // Describe returns a human-readable description of the interaction
@@ -54,7 +119,7 @@ func (interaction *Interaction) Describe() string {
 	const elideCmdAt = 40
 	const elideResponseAt = 25
 	format := fmt.Sprintf("%%-%ds  ?  %%-%ds", elideCmdAt, elideResponseAt)
-	name := interaction.Cmd
+	name := strings.Replace(interaction.Cmd, "\n", " ", -1) // multi-line commands are shown in one line
 	if len(interaction.Caption) != 0 {
 		name = interaction.Caption
 	}
@@ -66,12 +131,55 @@ func (interaction *Interaction) Describe() string {
 	return result
 }
 
+// Location returns the position of the command in the Markdown source in the "README.md:142" format
+func (interaction *Interaction) Location() string {
+	if !interaction.CommandSpan.Known() {
+		return interaction.Filename
+	}
+	return fmt.Sprintf("%s:%d", interaction.Filename, interaction.CommandSpan.FirstLine)
+}
+
 // DescribeFull returns a long-form description of the interaction
 func (interaction *Interaction) DescribeFull() string {
-	response := strings.Join(interaction.Response, "\n")
-	output := strings.Join(interaction.Output, "\n")
-	description := fmt.Sprintf("got: \"%s\", want: \"%s\"", response, output)
-	return description
+	var description []string
+	if len(interaction.Comment) > 0 {
+		description = append(description, fmt.Sprintf("%s: %s", interaction.Location(), interaction.Comment))
+	} else {
+		description = append(description, fmt.Sprintf("%s: %s", interaction.Location(), interaction.Result()))
+	}
+	if unified := interaction.Diff(); len(unified) > 0 {
+		description = append(description, unified...)
+	} else {
+		description = append(description, fmt.Sprintf("output: \"%s\"", strings.Join(interaction.comparedOutput(), "\n")))
+	}
+	return strings.Join(description, "\n")
+}
+
+// Diff returns a unified diff of the expected response and the output, or nothing if they match
+// Output after an ellipsis in the expected response is not compared, and lines that are regular
+// expressions are considered equal to the output lines they match.
+func (interaction *Interaction) Diff() []string {
+	lines := interaction.compareLines()
+	if index := interaction.ellipsis(); index >= 0 {
+		lines = append(lines, diff.Line{Operation: diff.Equal, Text: interaction.Response[index]})
+	}
+	return diff.Unified(lines, 3)
+}
+
+// compareLines computes the differences between the expected response and the compared output
+// Only the expected lines before an ellipsis and the same number of output lines are compared.
+func (interaction *Interaction) compareLines() []diff.Line {
+	expected := interaction.Response
+	output := interaction.comparedOutput()
+	if index := interaction.ellipsis(); index >= 0 {
+		expected = expected[:index]
+		if len(output) > index {
+			output = output[:index]
+		}
+	}
+	_, allRegex := interaction.Attributes[RegexOption]
+	matcher, _ := newMatcher(expected, allRegex) // invalid regular expressions do not match any line
+	return diff.Compute(expected, output, matcher.match)
 }
 
 // Result returns a human readable description of the result of the interaction
@@ -92,6 +200,16 @@ func (interaction *Interaction) Result() string {
 		return "FAIL (mismatch)"
 	case ResultError:
 		return "FAIL (execution failed)"
+	case ResultTimeout:
+		return "ERROR (timeout)"
+	case ResultSkipped:
+		return "SKIPPED"
+	case ResultExpectedFailure:
+		return "XFAIL (expected failure)"
+	case ResultUnexpectedSuccess:
+		return "FAIL (unexpected success)"
+	case ResultShellExited:
+		return "ERROR (shell exited)"
 	default:
 		return "YOU FOUND A BUG!!11!1!"
 	}
@@ -99,7 +217,8 @@ func (interaction *Interaction) Result() string {
 
 // HasFailure returns true if the interaction failed (not on execution errors)
 func (interaction *Interaction) HasFailure() bool {
-	return interaction.ResultCode == ResultError || interaction.ResultCode == ResultMismatch
+	return interaction.ResultCode == ResultError || interaction.ResultCode == ResultMismatch ||
+		interaction.ResultCode == ResultUnexpectedSuccess
 }
 
 // New creates an empty interaction with a Caption
@@ -109,16 +228,31 @@ func New(caption string) *Interaction {
 	return interaction
 }
 
+// Skip marks the interaction as skipped, it will not be executed
+func (interaction *Interaction) Skip(reason string) {
+	interaction.ResultCode = ResultSkipped
+	interaction.Comment = reason
+}
+
+// ellipsis returns the index of the ellipsis in the expected response, or -1 if there is none
+func (interaction *Interaction) ellipsis() int {
+	for index, line := range interaction.Response {
+		if strings.TrimSpace(line) == "..." {
+			return index
+		}
+	}
+	return -1
+}
+
 // evaluateResponse compares the output to the expected response, and respects "ellipsis" (don't care from here on forward)
 func (interaction *Interaction) evaluateResponse(response []string) bool {
 	output := response
 	expected := interaction.Response
-	for index, line := range interaction.Response {
-		if strings.TrimSpace(line) == "..." {
+	if index := interaction.ellipsis(); index >= 0 {
+		if len(output) > index {
 			output = response[:index]
-			expected = interaction.Response[:index]
-			break
 		}
+		expected = interaction.Response[:index]
 	}
 	if len(output) == 0 && len(expected) == 0 {
 		return true
@@ -127,9 +261,12 @@ func (interaction *Interaction) evaluateResponse(response []string) bool {
 }
 
 // Execute the interaction and store the result
-func (interaction *Interaction) Execute(shell *shell.Shell) error {
-	const ExitCodeOption = "shelldocexitcode"
-	const ExitCodeWhatever = "shelldocwhatever"
+// The timeout applies unless the interaction specifies its own using the TimeoutOption. Zero means no timeout.
+// If the executor cannot interrupt commands, the timeout is ignored.
+func (interaction *Interaction) Execute(sh shell.Executor, timeout time.Duration) error {
+	if interaction.ResultCode == ResultSkipped {
+		return nil
+	}
 	var expectedExitCode int
 	if expectedExitCodeOption, ok := interaction.Attributes[ExitCodeOption]; ok {
 		if value, err := strconv.Atoi(expectedExitCodeOption); err == nil {
@@ -139,35 +276,205 @@ func (interaction *Interaction) Execute(shell *shell.Shell) error {
 		}
 	}
 	expectedWhatever := false
-	if _, ok := interaction.Attributes[ExitCodeWhatever]; ok {
+	if _, ok := interaction.Attributes[ExitCodeWhateverOption]; ok {
 		expectedWhatever = true
 	}
+	if timeoutOption, ok := interaction.Attributes[TimeoutOption]; ok {
+		if value, err := time.ParseDuration(timeoutOption); err == nil {
+			timeout = value
+		} else {
+			return fmt.Errorf("argument to %s needs to be a duration (like 30s), got \"%s\"", TimeoutOption, timeoutOption)
+		}
+	}
+	stream := StreamStdout
+	if streamOption, ok := interaction.Attributes[StreamOption]; ok {
+		switch streamOption {
+		case StreamStdout, StreamStderr, StreamMerged:
+			stream = streamOption
+		default:
+			return fmt.Errorf("argument to %s needs to be one of %s, %s or %s, got \"%s\"", StreamOption,
+				StreamStdout, StreamStderr, StreamMerged, streamOption)
+		}
+	}
+	capabilities := sh.Capabilities()
+	if stream == StreamStderr && !capabilities.ErrorOutput {
+		return fmt.Errorf("%s=%s is not supported, the executor does not capture the error output separately", StreamOption, StreamStderr)
+	}
+	if timeout > 0 && !capabilities.Timeout {
+		timeout = 0 // the executor cannot interrupt commands
+	}
+	command := interaction.Cmd
+	if interaction.UseSudo {
+		command = fmt.Sprintf("sudo -- sh -c %s", shell.Quote(command))
+	}
+	if stream == StreamMerged {
+		// let the shell merge the streams, to preserve the order of the lines
+		command = fmt.Sprintf("{\n%s\n} 2>&1", command)
+	}
 	// execute the command in the shell
-	output, rc, err := shell.ExecuteCommand(interaction.Cmd)
+	started := time.Now()
+	output, errorOutput, rc, err := sh.ExecuteCommand(command, timeout)
+	interaction.Duration = time.Since(started)
 	interaction.Output = output
+	interaction.ErrorOutput = errorOutput
+	interaction.ExitCode = rc
 	// compare the results
-	if err != nil {
+	if err == shell.ErrTimeout {
+		interaction.ResultCode = ResultTimeout
+		interaction.Comment = fmt.Sprintf("command did not finish within %v", timeout)
+		return fmt.Errorf("command did not finish within %v", timeout)
+	} else if exitErr, ok := err.(*shell.ExitError); ok {
+		interaction.ResultCode = ResultShellExited
+		interaction.Comment = exitErr.Error()
+		return exitErr
+	} else if err != nil {
 		interaction.ResultCode = ResultExecutionError
 		interaction.Comment = err.Error()
 		return fmt.Errorf("unable to execute command: %v", err)
 	} else if expectedWhatever == false && rc != expectedExitCode {
 		interaction.ResultCode = ResultError
 		interaction.Comment = fmt.Sprintf("command exited with non-zero exit code %d", rc)
-	} else if interaction.evaluateResponse(output) {
+	} else if interaction.evaluateResponse(interaction.comparedOutput()) {
 		interaction.ResultCode = ResultMatch
 		interaction.Comment = ""
-	} else if interaction.compareRegex(output) {
+	} else if match, comment, err := interaction.compareRegex(interaction.comparedOutput()); err != nil {
+		interaction.ResultCode = ResultExecutionError
+		interaction.Comment = err.Error()
+		return err
+	} else if match {
 		interaction.ResultCode = ResultRegexMatch
+		interaction.Comment = ""
 	} else {
 		interaction.ResultCode = ResultMismatch
-		interaction.Comment = ""
+		interaction.Comment = comment
+	}
+	if _, ok := interaction.Attributes[ExpectedFailureOption]; ok {
+		if interaction.HasFailure() {
+			interaction.ResultCode = ResultExpectedFailure
+		} else {
+			interaction.ResultCode = ResultUnexpectedSuccess
+			interaction.Comment = fmt.Sprintf("command is marked with %s, but succeeded", ExpectedFailureOption)
+		}
 	}
 	return nil
 }
 
-func (interaction *Interaction) compareRegex(output []string) bool {
-	// match, err := regexp.MatchString(interaction.AlternativeRegEx, output); err
-	return false
+// UpdatedResponse returns the output of the executed interaction in the form of an expected response
+// Expected lines that match the output are kept as they are, so that regular expressions remain in place, and
+// only the lines that do not match are replaced. If the expected response contains an ellipsis, the output is cut
+// off at the ellipsis, which is preserved. If all lines are regular expressions, the output lines are quoted.
+func (interaction *Interaction) UpdatedResponse() []string {
+	_, allRegex := interaction.Attributes[RegexOption]
+	var response []string
+	for _, line := range interaction.compareLines() {
+		switch line.Operation {
+		case diff.Equal: // the expected line, which may be a regular expression
+			response = append(response, line.Text)
+		case diff.Insert:
+			if allRegex {
+				// every line of the response is a regular expression, the output needs to match itself
+				response = append(response, regexp.QuoteMeta(line.Text))
+			} else {
+				response = append(response, line.Text)
+			}
+		}
+	}
+	if index := interaction.ellipsis(); index >= 0 {
+		response = append(response, interaction.Response[index])
+	}
+	return response
+}
+
+// comparedOutput returns the output selected by the StreamOption, which is compared to the expected response
+func (interaction *Interaction) comparedOutput() []string {
+	if interaction.Attributes[StreamOption] == StreamStderr {
+		return interaction.ErrorOutput
+	}
+	return interaction.Output
+}
+
+// compareRegex compares the output line by line to the expected response, matching lines marked as regular expressions
+// as anchored regexes and all other lines literally. It respects the ellipsis like evaluateResponse. If the response
+// contains no regular expressions, it returns false. On a mismatch, the returned comment describes the first mismatching line.
+func (interaction *Interaction) compareRegex(response []string) (bool, string, error) {
+	_, allRegex := interaction.Attributes[RegexOption]
+	expected := interaction.Response
+	output := response
+	index := interaction.ellipsis()
+	if index >= 0 {
+		expected = interaction.Response[:index]
+	}
+	hasRegex := allRegex
+	for _, line := range expected {
+		hasRegex = hasRegex || strings.HasSuffix(line, RegexMarker)
+	}
+	if !hasRegex {
+		return false, "", nil
+	}
+	if index >= 0 && len(output) > len(expected) {
+		output = output[:len(expected)]
+	}
+	matcher, err := newMatcher(expected, allRegex)
+	if err != nil {
+		return false, "", err
+	}
+	for index, line := range expected {
+		if index >= len(output) {
+			return false, fmt.Sprintf("line %d: missing output, want \"%s\"", index+1, line), nil
+		}
+		if matcher.match(line, output[index]) {
+			continue
+		}
+		if allRegex || strings.HasSuffix(line, RegexMarker) {
+			return false, fmt.Sprintf("line %d: got \"%s\", want match for regular expression \"%s\"", index+1,
+				output[index], strings.TrimSuffix(line, RegexMarker)), nil
+		}
+		return false, fmt.Sprintf("line %d: got \"%s\", want \"%s\"", index+1, output[index], line), nil
+	}
+	if len(output) > len(expected) {
+		return false, fmt.Sprintf("line %d: got unexpected output \"%s\"", len(expected)+1, output[len(expected)]), nil
+	}
+	return true, "", nil
+}
+
+// matcher compares lines of output to the lines of an expected response
+// Lines marked as regular expressions (or all lines, if allRegex is true) are matched as anchored regexes, which
+// are compiled once, since the diff compares every expected line to many lines of output.
+type matcher struct {
+	allRegex bool
+	patterns map[string]*regexp.Regexp // the compiled regular expressions by expected line, nil if invalid
+}
+
+// newMatcher compiles the regular expressions in the expected lines
+// If a regular expression is invalid, the error describes the first one, and the matcher reports its line as not matching.
+func newMatcher(expected []string, allRegex bool) (*matcher, error) {
+	result := &matcher{allRegex: allRegex, patterns: make(map[string]*regexp.Regexp)}
+	var first error
+	for index, line := range expected {
+		if _, ok := result.patterns[line]; ok || !result.isRegex(line) {
+			continue
+		}
+		rx, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", strings.TrimSuffix(line, RegexMarker)))
+		if err != nil && first == nil {
+			first = fmt.Errorf("invalid regular expression in line %d of the expected response: %v", index+1, err)
+		}
+		result.patterns[line] = rx
+	}
+	return result, first
+}
+
+// isRegex returns true if the expected line is a regular expression
+func (matcher *matcher) isRegex(expected string) bool {
+	return matcher.allRegex || strings.HasSuffix(expected, RegexMarker)
+}
+
+// match compares a line of the expected response passed to newMatcher to a line of output
+func (matcher *matcher) match(expected, actual string) bool {
+	if !matcher.isRegex(expected) {
+		return expected == actual
+	}
+	rx := matcher.patterns[expected] // nil if the regular expression is invalid
+	return rx != nil && rx.MatchString(actual)
 }
 
 func elideString(text string, length int) string {
@@ -0,0 +1,129 @@
+package tokenizer
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/endocode/shelldoc/pkg/shell"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeExecutor returns prepared results instead of executing the commands in a shell
+type fakeExecutor struct {
+	output       []string
+	errorOutput  []string
+	rc           int
+	err          error
+	capabilities shell.Capabilities
+	commands     []string
+	timeouts     []time.Duration
+}
+
+func (fake *fakeExecutor) Start(dir string, env []string) error { return nil }
+func (fake *fakeExecutor) Exit() error                          { return nil }
+func (fake *fakeExecutor) Kill() error                          { return nil }
+func (fake *fakeExecutor) Capabilities() shell.Capabilities     { return fake.capabilities }
+func (fake *fakeExecutor) ExecuteCommand(command string, timeout time.Duration) ([]string, []string, int, error) {
+	fake.commands = append(fake.commands, command)
+	fake.timeouts = append(fake.timeouts, timeout)
+	return fake.output, fake.errorOutput, fake.rc, fake.err
+}
+
+func TestCompareRegex(t *testing.T) {
+	interaction := Interaction{Response: []string{"Hello", "W.+d (re)"}}
+	match, _, err := interaction.compareRegex([]string{"Hello", "World"})
+	require.NoError(t, err, "The regular expression is valid")
+	require.True(t, match, "The second line matches the regular expression")
+	match, comment, err := interaction.compareRegex([]string{"Hello", "Moon"})
+	require.NoError(t, err, "The regular expression is valid")
+	require.False(t, match, "The second line does not match the regular expression")
+	require.Contains(t, comment, "line 2", "The comment names the mismatching line")
+	match, _, err = interaction.compareRegex([]string{"Hello", "World!"})
+	require.NoError(t, err, "The regular expression is valid")
+	require.False(t, match, "Regular expressions are anchored")
+	match, _, err = interaction.compareRegex([]string{"Hello", "World", "again"})
+	require.NoError(t, err, "The regular expression is valid")
+	require.False(t, match, "Additional output lines are a mismatch")
+}
+
+func TestCompareRegexOption(t *testing.T) {
+	interaction := Interaction{
+		Response:   []string{"[0-9]+", "..."},
+		Attributes: map[string]string{RegexOption: ""},
+	}
+	match, _, err := interaction.compareRegex([]string{"42", "and more"})
+	require.NoError(t, err, "The regular expression is valid")
+	require.True(t, match, "With shelldocregex, all lines are regular expressions, and the ellipsis is respected")
+	interaction.Response = []string{"("}
+	_, _, err = interaction.compareRegex([]string{"("})
+	require.Error(t, err, "Invalid regular expressions are reported")
+	plain := Interaction{Response: []string{"Hello"}}
+	match, _, err = plain.compareRegex([]string{"Hello"})
+	require.NoError(t, err, "There are no regular expressions to compile")
+	require.False(t, match, "Without regular expressions, compareRegex does not report a match")
+}
+
+func TestDiff(t *testing.T) {
+	interaction := Interaction{
+		Response: []string{"Hello", "Moon", "..."},
+		Output:   []string{"Hello", "World", "and", "more"},
+	}
+	require.Equal(t, []string{"--- expected", "+++ actual", "@@ -1,3 +1,3 @@", " Hello", "-Moon", "+World", " ..."},
+		interaction.Diff(), "Output after the ellipsis is not compared")
+	interaction.Response = []string{"Hello", "W.+d (re)", "..."}
+	require.Empty(t, interaction.Diff(), "Lines matching regular expressions are not reported as differences")
+	interaction.Output = nil
+	require.Contains(t, interaction.Diff(), "-Hello", "Missing output is reported as a difference")
+}
+
+func TestExecute(t *testing.T) {
+	capabilities := shell.Capabilities{Timeout: true, ErrorOutput: true}
+	interaction := Interaction{Cmd: "echo Hello", Response: []string{"Hello"}}
+	executor := &fakeExecutor{output: []string{"Hello"}, capabilities: capabilities}
+	require.NoError(t, interaction.Execute(executor, time.Second), "The command is executed")
+	require.Equal(t, ResultMatch, interaction.ResultCode, "The output matches the response")
+	require.Equal(t, []string{"echo Hello"}, executor.commands, "The command is passed to the executor")
+	require.Equal(t, []time.Duration{time.Second}, executor.timeouts, "The timeout is passed to the executor")
+
+	interaction = Interaction{Cmd: "echo Hello", Response: []string{"Hello"}}
+	executor = &fakeExecutor{output: []string{"World"}, capabilities: capabilities}
+	require.NoError(t, interaction.Execute(executor, 0), "The command is executed")
+	require.Equal(t, ResultMismatch, interaction.ResultCode, "The output does not match the response")
+
+	interaction = Interaction{Cmd: "false", Attributes: map[string]string{ExitCodeOption: "1"}}
+	executor = &fakeExecutor{rc: 1, capabilities: capabilities}
+	require.NoError(t, interaction.Execute(executor, 0), "The command is executed")
+	require.Equal(t, ResultMatch, interaction.ResultCode, "The exit code is expected")
+
+	interaction = Interaction{Cmd: "true"}
+	executor = &fakeExecutor{rc: 2, capabilities: capabilities}
+	require.NoError(t, interaction.Execute(executor, 0), "The command is executed")
+	require.Equal(t, ResultError, interaction.ResultCode, "The exit code is not zero")
+
+	interaction = Interaction{Cmd: "sleep 10"}
+	executor = &fakeExecutor{err: shell.ErrTimeout, capabilities: capabilities}
+	require.Error(t, interaction.Execute(executor, time.Second), "The timeout is reported")
+	require.Equal(t, ResultTimeout, interaction.ResultCode, "The command timed out")
+
+	interaction = Interaction{Cmd: "echo Hello"}
+	executor = &fakeExecutor{err: errors.New("shell is gone"), capabilities: capabilities}
+	require.Error(t, interaction.Execute(executor, 0), "The execution error is reported")
+	require.Equal(t, ResultExecutionError, interaction.ResultCode, "The command could not be executed")
+}
+
+func TestExecuteCapabilities(t *testing.T) {
+	interaction := Interaction{Cmd: "echo Hello >&2", Response: []string{"Hello"}, Attributes: map[string]string{StreamOption: StreamStderr}}
+	executor := &fakeExecutor{}
+	require.Error(t, interaction.Execute(executor, 0), "The error output cannot be compared without the ErrorOutput capability")
+	require.Empty(t, executor.commands, "The command is not executed")
+
+	interaction = Interaction{Cmd: "echo Hello", Response: []string{"Hello"}}
+	executor = &fakeExecutor{output: []string{"Hello"}}
+	require.NoError(t, interaction.Execute(executor, time.Second), "The command is executed")
+	require.Equal(t, []time.Duration{0}, executor.timeouts, "The timeout is ignored without the Timeout capability")
+}
@@ -0,0 +1,90 @@
+package tokenizer
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"strings"
+)
+
+// Span describes a range of lines in the Markdown source
+type Span struct {
+	// FirstLine is the number of the first line of the span, starting at 1 (zero if the position is unknown)
+	FirstLine int
+	// LastLine is the number of the last line of the span, LastLine is FirstLine-1 for an empty span
+	LastLine int
+	// Offset is the byte offset of the beginning of the span
+	Offset int
+	// Length is the length of the span in bytes, including the trailing newline
+	Length int
+}
+
+// Known returns true if the position of the span in the source was found
+func (span Span) Known() bool {
+	return span.FirstLine > 0
+}
+
+// Empty returns true if the span does not contain any lines
+func (span Span) Empty() bool {
+	return span.LastLine < span.FirstLine
+}
+
+// following returns an empty span located directly after the span
+func (span Span) following() Span {
+	if !span.Known() {
+		return Span{}
+	}
+	return Span{span.LastLine + 1, span.LastLine, span.Offset + span.Length, 0}
+}
+
+// extend returns a span that ranges from the beginning of the span to the end of other
+func (span Span) extend(other Span) Span {
+	if !other.Known() {
+		return span
+	}
+	if !span.Known() || span.Empty() {
+		return other
+	}
+	return Span{span.FirstLine, other.LastLine, span.Offset, other.Offset + other.Length - span.Offset}
+}
+
+// locator finds the lines of the code blocks in the Markdown source.
+// The Markdown parser does not report source positions, so the locator searches for the lines of the code blocks
+// in the order in which the parser reports them. Leading and trailing white space is ignored.
+type locator struct {
+	lines   []string
+	offsets []int
+	next    int
+}
+
+// newLocator prepares a locator for the input data
+func newLocator(data []byte) *locator {
+	result := new(locator)
+	offset := 0
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		result.lines = append(result.lines, line)
+		result.offsets = append(result.offsets, offset)
+		offset += len(line)
+	}
+	return result
+}
+
+// locate finds the next line in the source with the given content and returns its span
+// If the line is not found, an unknown span is returned and the search position remains unchanged.
+func (locator *locator) locate(content string) Span {
+	if locator == nil {
+		return Span{}
+	}
+	content = strings.TrimSpace(content)
+	for index := locator.next; index < len(locator.lines); index++ {
+		if strings.TrimSpace(locator.lines[index]) == content {
+			locator.next = index + 1
+			return Span{index + 1, index + 1, locator.offsets[index], len(locator.lines[index])}
+		}
+	}
+	return Span{}
+}
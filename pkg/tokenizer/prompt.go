@@ -0,0 +1,87 @@
+package tokenizer
+
+// This file is part of shelldoc.
+// © 2019, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultPrompts are the trigger characters that mark a line in a code block as a command by default
+var DefaultPrompts = []string{"$", ">"}
+
+// Prompt is a trigger that marks a line in a code block as a command
+// Prompts are either literal strings that need to be followed by white space, like "$", or regular expressions
+// enclosed in slashes, like "/\w+@\w+:\S*\$/".
+type Prompt struct {
+	// Spec contains the prompt as it was specified
+	Spec string
+	// Root is true if commands with this prompt require root privileges
+	Root bool
+	rx   *regexp.Regexp
+}
+
+// NewPrompt creates a prompt from its specification
+func NewPrompt(spec string, root bool) (Prompt, error) {
+	var expression string
+	if len(spec) > 2 && strings.HasPrefix(spec, "/") && strings.HasSuffix(spec, "/") {
+		expression = fmt.Sprintf("^(?:%s)\\s*(.+)$", spec[1:len(spec)-1])
+	} else if len(spec) > 0 {
+		expression = fmt.Sprintf("^%s\\s+(.+)$", regexp.QuoteMeta(spec))
+	} else {
+		return Prompt{}, fmt.Errorf("empty prompt")
+	}
+	rx, err := regexp.Compile(expression)
+	if err != nil {
+		return Prompt{}, fmt.Errorf("invalid regular expression in prompt %s: %v", spec, err)
+	}
+	return Prompt{spec, root, rx}, nil
+}
+
+// NewPrompts creates prompts from a list of specifications
+func NewPrompts(specs []string, root bool) ([]Prompt, error) {
+	var prompts []Prompt
+	for _, spec := range specs {
+		prompt, err := NewPrompt(spec, root)
+		if err != nil {
+			return nil, err
+		}
+		prompts = append(prompts, prompt)
+	}
+	return prompts, nil
+}
+
+// match returns the command if the line starts with the prompt
+func (prompt Prompt) match(line string) (string, bool) {
+	match := prompt.rx.FindStringSubmatch(line)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// splitPromptList splits a comma-separated list of prompts, as used in code block attributes
+// Commas inside of regular expression prompts (enclosed in slashes) do not separate prompts.
+func splitPromptList(list string) []string {
+	var specs []string
+	for len(list) > 0 {
+		end := strings.Index(list, ",")
+		if strings.HasPrefix(list, "/") {
+			if closing := strings.Index(list[1:], "/,"); closing >= 0 {
+				end = closing + 2
+			} else {
+				end = -1
+			}
+		}
+		if end < 0 {
+			specs = append(specs, list)
+			break
+		}
+		specs = append(specs, list[:end])
+		list = list[end+1:]
+	}
+	return specs
+}
@@ -5,9 +5,11 @@ package tokenizer
 // SPDX-License-Identifier: LGPL-3.0
 
 import (
+	"fmt"
 	"log"
 	"regexp"
 	"strings"
+	"unicode"
 
 	"gopkg.in/russross/blackfriday.v2"
 )
@@ -18,39 +20,181 @@ type Visitor struct {
 	CodeBlock func(visitor *Visitor, node *blackfriday.Node) blackfriday.WalkStatus
 	// FencedCodeBlock should be assigned a function to be called when a fenced code block is encountered
 	FencedCodeBlock func(visitor *Visitor, node *blackfriday.Node) blackfriday.WalkStatus
+	// HTMLComment may be assigned a function to be called when HTML is encountered, which may contain directives
+	HTMLComment func(visitor *Visitor, node *blackfriday.Node) blackfriday.WalkStatus
+	// Filename is the path of the parsed file, it is stored in the interactions to report their location
+	Filename string
+	// Prompts contains the triggers that mark lines as commands, unless a code block specifies its own
+	Prompts []Prompt
 	// After parsing, Interactions will hold the shell interactions found in the file
 	Interactions []*Interaction
+	// After parsing, Setup will hold the hidden setup scripts found in the file
+	Setup []*Interaction
+	// After parsing, Teardown will hold the hidden teardown scripts found in the file
+	Teardown []*Interaction
+	// locator finds the position of the interactions in the input data
+	locator *locator
+	// err holds the first error encountered while parsing, it is returned by Tokenize
+	err error
 }
 
-const cmdEx = "^[\\$>]\\s+(.+)$"
+// continuationEx matches lines of a multi-line command that start with a PS2-style continuation prompt
+const continuationEx = "^>(?:\\s(.*))?$"
+
+// heredocEx matches the redirection operator and the delimiter of a here-document
+const heredocEx = "<<-?\\s*(?:'([^']+)'|\"([^\"]+)\"|\\\\?([A-Za-z_][A-Za-z0-9_]*))"
 
 // handleCodeBlock parses the interactions in a code block and adds them to the Visitor
 func handleCodeBlock(visitor *Visitor, node *blackfriday.Node) blackfriday.WalkStatus {
-	cmdRx := regexp.MustCompile(cmdEx)
-
 	lines := strings.Split(string(node.Literal), "\n")
+	visitor.parseInteractions(lines, "", nil)
+	return blackfriday.GoToNext
+}
+
+// parseInteractions splits the lines of a code block into commands and responses and adds them to the Visitor
+// Commands may span multiple lines if a line ends in a backslash or starts a here-document. Lines that continue a
+// command after a backslash may start with a continuation prompt ("> "), which is removed. The lines of a
+// here-document are kept as they are.
+func (visitor *Visitor) parseInteractions(lines []string, language string, attributes map[string]string) {
+	prompts, err := visitor.blockPrompts(attributes)
+	if err != nil {
+		if visitor.err == nil {
+			visitor.err = err
+		}
+		return
+	}
+	continuationRx := regexp.MustCompile(continuationEx)
+
 	var current *Interaction
+	continued := false    // the last line of the current command ended in a backslash
+	var heredocs []string // delimiters of the here-documents of the current command that have not ended yet
 	for _, line := range lines {
-		line = strings.TrimSpace(line)
+		trimmed := strings.TrimSpace(line)
+		if current != nil && (continued || len(heredocs) > 0) && (len(trimmed) > 0 || len(heredocs) > 0) {
+			// the line continues the current command
+			content := strings.TrimRight(line, " \t\r")
+			if len(heredocs) == 0 {
+				content = trimmed
+			}
+			if match := continuationRx.FindStringSubmatch(trimmed); match != nil && len(heredocs) == 0 {
+				content = match[1]
+			}
+			current.Cmd = current.Cmd + "\n" + content
+			if len(trimmed) > 0 {
+				current.CommandSpan = current.CommandSpan.extend(visitor.locator.locate(trimmed))
+				current.ResponseSpan = current.CommandSpan.following()
+			}
+			if len(heredocs) > 0 {
+				if strings.TrimSpace(content) == heredocs[0] {
+					heredocs = heredocs[1:]
+				}
+				continued = false
+			} else {
+				continued = strings.HasSuffix(content, "\\")
+				heredocs = findHeredocs(content)
+			}
+			continue
+		}
+		continued = false
+		line = trimmed
 		if len(line) == 0 {
 			continue
 		}
-		match := cmdRx.FindStringSubmatch(line)
-		if len(match) > 1 {
+		var prompt Prompt
+		var cmd string
+		matched := false
+		for _, prompt = range prompts {
+			if cmd, matched = prompt.match(line); matched {
+				break
+			}
+		}
+		if matched {
 			// begin a new command
 			current = new(Interaction)
+			current.Language = language
+			current.Attributes = attributes
+			current.Filename = visitor.Filename
 			visitor.Interactions = append(visitor.Interactions, current)
-			cmd := match[1]
 			current.Cmd = cmd
+			current.RootRequired = prompt.Root
+			current.CommandSpan = visitor.locator.locate(line)
+			current.ResponseSpan = current.CommandSpan.following()
+			continued = strings.HasSuffix(cmd, "\\")
+			heredocs = findHeredocs(cmd)
 		} else {
 			if current == nil {
-				log.Printf("no trigger prefix ($ or >), skipping line: %s\n", line)
+				log.Printf("no trigger prefix, skipping line: %s\n", line)
 				continue
 			}
 			current.Response = append(current.Response, line)
+			current.ResponseSpan = current.ResponseSpan.extend(visitor.locator.locate(line))
 		}
 	}
-	return blackfriday.GoToNext
+}
+
+// blockPrompts returns the prompts of a code block, the prompt options replace the prompts of the Visitor
+func (visitor *Visitor) blockPrompts(attributes map[string]string) ([]Prompt, error) {
+	var prompts, rootPrompts []Prompt
+	for _, prompt := range visitor.Prompts {
+		if prompt.Root {
+			rootPrompts = append(rootPrompts, prompt)
+		} else {
+			prompts = append(prompts, prompt)
+		}
+	}
+	var err error
+	if spec, ok := attributes[PromptOption]; ok {
+		if prompts, err = NewPrompts(splitPromptList(spec), false); err != nil {
+			return nil, fmt.Errorf("argument to %s is invalid: %v", PromptOption, err)
+		}
+	}
+	if spec, ok := attributes[RootPromptOption]; ok {
+		if rootPrompts, err = NewPrompts(splitPromptList(spec), true); err != nil {
+			return nil, fmt.Errorf("argument to %s is invalid: %v", RootPromptOption, err)
+		}
+	}
+	return append(prompts, rootPrompts...), nil
+}
+
+// findHeredocs returns the delimiters of the here-documents started in a line of a command
+// Redirection operators in quotes and comments do not start here-documents.
+func findHeredocs(line string) []string {
+	heredocRx := regexp.MustCompile("^" + heredocEx)
+	var delimiters []string
+	var quote byte // the quote character of the quoted string the scan is in, or zero
+	for index := 0; index < len(line); index++ {
+		char := line[index]
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			} else if char == '\\' && quote == '"' {
+				index++
+			}
+		case char == '\\':
+			index++
+		case char == '\'' || char == '"':
+			quote = char
+		case char == '#' && (index == 0 || strings.ContainsRune(" \t;|&(", rune(line[index-1]))):
+			return delimiters // the rest of the line is a comment
+		case strings.HasPrefix(line[index:], "<<<"):
+			index += 2 // a here-string, not a here-document
+		case strings.HasPrefix(line[index:], "<<"):
+			match := heredocRx.FindStringSubmatchIndex(line[index:])
+			if match == nil {
+				index++
+				continue
+			}
+			for group := 1; group <= 3; group++ {
+				if match[2*group] >= 0 {
+					delimiters = append(delimiters, line[index+match[2*group]:index+match[2*group+1]])
+					break
+				}
+			}
+			index += match[1] - 1
+		}
+	}
+	return delimiters
 }
 
 // parseCodeBlockInfoString "best-faith" parses the info string and returns the language end the attributes
@@ -73,7 +217,7 @@ func parseCodeBlockInfoString(infostring string) (string, map[string]string) {
 		attributesContentMatch := attributesContentRx.FindStringSubmatch(attributesString)
 		if attributesContentMatch != nil {
 			attributesContent := attributesContentMatch[1]
-			elements := strings.Split(attributesContent, " ")
+			elements := splitAttributes(attributesContent)
 			for _, element := range elements {
 				if len(element) == 0 || !strings.HasPrefix(element, "shelldoc") {
 					continue
@@ -93,44 +237,72 @@ func parseCodeBlockInfoString(infostring string) (string, map[string]string) {
 	return language, attributes
 }
 
+// splitAttributes splits the attributes of a code block at white space
+// Values in double quotes may contain white space, like shelldocprompt="PS C:\>", the quotes are removed.
+func splitAttributes(content string) []string {
+	var elements []string
+	var element strings.Builder
+	quoted := false
+	for _, char := range content {
+		switch {
+		case char == '"':
+			quoted = !quoted
+		case unicode.IsSpace(char) && !quoted:
+			if element.Len() > 0 {
+				elements = append(elements, element.String())
+				element.Reset()
+			}
+		default:
+			element.WriteRune(char)
+		}
+	}
+	if element.Len() > 0 {
+		elements = append(elements, element.String())
+	}
+	return elements
+}
+
 // handleFencedCodeBlock parses the interactions in a fenced code block and adds them to the Visitor
 func handleFencedCodeBlock(visitor *Visitor, node *blackfriday.Node) blackfriday.WalkStatus {
-	cmdRx := regexp.MustCompile(cmdEx)
-
+	infostring := string(node.Info)
+	language, attributes := parseCodeBlockInfoString(infostring) // on error, language and attributes remain empty
 	lines := strings.Split(string(node.Literal), "\n")
-	if len(lines) < 2 {
-		// technically, this should not happen, line 0 is the opening line of the code block (```),
-		// the last line is the closer
-		log.Printf("encountered a fenced code block with no info string, ignored")
+	visitor.parseInteractions(lines, language, attributes)
+	return blackfriday.GoToNext
+}
+
+// Directives are HTML comments that contain hidden scripts, which are executed but not shown to the reader
+const (
+	// SetupDirective marks a script that is executed before the interactions of the file
+	SetupDirective = "shelldoc-setup"
+	// TeardownDirective marks a script that is executed after the interactions of the file
+	TeardownDirective = "shelldoc-teardown"
+)
+
+const directiveEx = "(?s)^<!--\\s*(shelldoc-setup|shelldoc-teardown)\\b(.*)-->\\s*$"
+
+// handleHTMLComment parses setup and teardown directives and adds them to the Visitor
+// The content of a directive is executed as one script, its output is ignored, but it needs to succeed.
+func handleHTMLComment(visitor *Visitor, node *blackfriday.Node) blackfriday.WalkStatus {
+	directiveRx := regexp.MustCompile(directiveEx)
+	match := directiveRx.FindStringSubmatch(string(node.Literal))
+	if match == nil {
 		return blackfriday.GoToNext
 	}
-	infostring := lines[0]
-	language, attributes := parseCodeBlockInfoString(infostring) // on error, language and attributes remain empty
-	// closer := lines[len(lines)-1] // closer is not parsed any further
-	lines = lines[1 : len(lines)-1]
-
-	var current *Interaction
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if len(line) == 0 {
-			continue
-		}
-		match := cmdRx.FindStringSubmatch(line)
-		if len(match) > 1 {
-			// begin a new command
-			current = new(Interaction)
-			current.Language = language
-			current.Attributes = attributes
-			visitor.Interactions = append(visitor.Interactions, current)
-			cmd := match[1]
-			current.Cmd = cmd
-		} else {
-			if current == nil {
-				log.Printf("no trigger prefix ($ or >), skipping: %s\n", line)
-				continue
-			}
-			current.Response = append(current.Response, line)
-		}
+	script := strings.TrimSpace(match[2])
+	if len(script) == 0 {
+		log.Printf("empty %s directive, ignored", match[1])
+		return blackfriday.GoToNext
+	}
+	interaction := New(match[1])
+	interaction.Cmd = script
+	interaction.Response = []string{"..."} // the output of the script does not matter
+	interaction.Filename = visitor.Filename
+	interaction.CommandSpan = visitor.locator.locate(strings.SplitN(string(node.Literal), "\n", 2)[0])
+	if match[1] == SetupDirective {
+		visitor.Setup = append(visitor.Setup, interaction)
+	} else {
+		visitor.Teardown = append(visitor.Teardown, interaction)
 	}
 	return blackfriday.GoToNext
 }
@@ -138,8 +310,10 @@ func handleFencedCodeBlock(visitor *Visitor, node *blackfriday.Node) blackfriday
 // NewInteractionVisitor creates a visitor configured with the default ineraction parser
 func NewInteractionVisitor() *Visitor {
 	visitor := new(Visitor)
+	visitor.Prompts, _ = NewPrompts(DefaultPrompts, false) // the default prompts are valid
 	visitor.CodeBlock = handleCodeBlock
 	visitor.FencedCodeBlock = handleFencedCodeBlock
+	visitor.HTMLComment = handleHTMLComment
 	return visitor
 }
 
@@ -147,18 +321,22 @@ func NewInteractionVisitor() *Visitor {
 // It checks for code blocks and calls the respective handlers.
 func (visitor *Visitor) visit(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
 	// log.Printf("%v: %s", node.Type, node.Literal)
-	if node.Type == blackfriday.CodeBlock && entering == true {
-		return visitor.CodeBlock(visitor, node)
-	} else if node.Type == blackfriday.Code && entering == true {
+	if node.Type == blackfriday.CodeBlock && node.IsFenced && entering == true {
 		return visitor.FencedCodeBlock(visitor, node)
+	} else if node.Type == blackfriday.CodeBlock && entering == true {
+		return visitor.CodeBlock(visitor, node)
+	} else if (node.Type == blackfriday.HTMLBlock || node.Type == blackfriday.HTMLSpan) && entering == true && visitor.HTMLComment != nil {
+		return visitor.HTMLComment(visitor, node)
 	}
 	return blackfriday.GoToNext
 }
 
 // Tokenize parses the data and calls the event handlers on visitor
+// Fenced code blocks are recognized as blocks, lines in them that look like Markdown headings are commands.
 func Tokenize(data []byte, visitor *Visitor) error {
-	md := blackfriday.New()
+	visitor.locator = newLocator(data)
+	md := blackfriday.New(blackfriday.WithExtensions(blackfriday.FencedCode))
 	om := md.Parse(data)
 	om.Walk(visitor.visit)
-	return nil
+	return visitor.err
 }
@@ -22,7 +22,7 @@ func codeBlockHandler(visitor *Visitor, node *blackfriday.Node) blackfriday.Walk
 func TestEchoTrue(t *testing.T) {
 	data, err := ioutil.ReadFile("samples/echotrue.md")
 	require.NoError(t, err, "Unable to read sample data file")
-	visitor := Visitor{codeBlockHandler, codeBlockHandler, nil}
+	visitor := Visitor{CodeBlock: codeBlockHandler, FencedCodeBlock: codeBlockHandler}
 	require.Zero(t, echoTrueCodeBlockCount, "Starting the counter")
 	Tokenize(data, &visitor)
 	require.Equal(t, echoTrueCodeBlockCount, 1, "There is one code block element in the sample file")
@@ -68,3 +68,127 @@ func TestTokenizeFenced(t *testing.T) {
 	require.Empty(t, second.Language, "No language was specified in the second block")
 	require.Empty(t, second.Attributes, "No attributes where specified in the second block")
 }
+
+func TestTokenizeSpans(t *testing.T) {
+	data, err := ioutil.ReadFile("samples/update.md")
+	require.NoError(t, err, "Unable to read sample data file")
+	visitor := NewInteractionVisitor()
+	visitor.Filename = "update.md"
+	Tokenize(data, visitor)
+	require.Equal(t, 6, len(visitor.Interactions), "There are six interactions in the sample file")
+	first := visitor.Interactions[0]
+	require.Equal(t, "update.md:5", first.Location(), "The location contains the file name and the line of the command")
+	require.Equal(t, 5, first.CommandSpan.FirstLine, "The first command is in line 5")
+	require.Equal(t, Span{6, 7, 119, 19}, first.ResponseSpan, "The first response spans lines 6 and 7")
+	require.Equal(t, "    Hello\n    Moon\n", string(data[first.ResponseSpan.Offset:first.ResponseSpan.Offset+first.ResponseSpan.Length]))
+	second := visitor.Interactions[1]
+	require.Equal(t, 12, second.CommandSpan.FirstLine, "The second command is in line 12")
+	require.True(t, second.ResponseSpan.Empty(), "The second command has no expected response")
+	require.Equal(t, 13, second.ResponseSpan.FirstLine, "The empty response is located after the command")
+	require.Equal(t, second.CommandSpan.Offset+second.CommandSpan.Length, second.ResponseSpan.Offset)
+	require.Equal(t, Span{14, 14, 226, 6}, visitor.Interactions[2].ResponseSpan, "The third response is in line 14")
+}
+
+func TestTokenizeMultiline(t *testing.T) {
+	data, err := ioutil.ReadFile("samples/multiline.md")
+	require.NoError(t, err, "Unable to read sample data file")
+	visitor := NewInteractionVisitor()
+	Tokenize(data, visitor)
+	require.Equal(t, 6, len(visitor.Interactions), "There are six interactions in the sample file")
+	require.Equal(t, "echo Hello \\\nWorld", visitor.Interactions[0].Cmd, "The backslash continues the command")
+	require.Equal(t, []string{"Hello World"}, visitor.Interactions[0].Response)
+	require.Equal(t, "echo Hello \\\nWorld", visitor.Interactions[1].Cmd, "The continuation prompt is removed")
+	heredoc := visitor.Interactions[2]
+	require.Equal(t, "cat <<EOF | grep -c \"^  Hello\"\n  Hello\nWorld\nEOF", heredoc.Cmd, "The here-document is part of the command")
+	require.Equal(t, []string{"1"}, heredoc.Response, "The response follows the delimiter")
+	require.Equal(t, Span{17, 20, heredoc.CommandSpan.Offset, heredoc.CommandSpan.Length}, heredoc.CommandSpan, "The command spans four lines")
+	require.Equal(t, "cat <<'END' | grep -c '^> '\n> Hello $HOME\nEND", visitor.Interactions[3].Cmd, "Quoted delimiters are recognized, the lines of the here-document are kept")
+	require.Equal(t, "echo done", visitor.Interactions[4].Cmd, "The prompt starts a new command after the here-document")
+	require.Equal(t, "echo \"a << b\"", visitor.Interactions[5].Cmd, "Quoted operators do not start here-documents")
+	require.Equal(t, []string{"a << b"}, visitor.Interactions[5].Response, "The response follows the command")
+}
+
+func TestFindHeredocs(t *testing.T) {
+	require.Equal(t, []string{"EOF"}, findHeredocs("cat <<EOF"))
+	require.Equal(t, []string{"A", "B"}, findHeredocs("cat <<-'A' - <<\"B\""))
+	require.Empty(t, findHeredocs("cat <<< \"Hello\""), "Here-strings are not here-documents")
+	require.Empty(t, findHeredocs("echo $((1<<2))"), "Shifts are not here-documents")
+	require.Empty(t, findHeredocs("echo \"a << b\""), "Operators in double quotes do not start here-documents")
+	require.Empty(t, findHeredocs("echo 'a <<b' \\<<c"), "Operators in single quotes or escaped do not start here-documents")
+	require.Empty(t, findHeredocs("echo Hello # cat <<EOF"), "Operators in comments do not start here-documents")
+	require.Equal(t, []string{"EOF"}, findHeredocs("echo \"#\" 'x' a#b <<EOF"), "Quotes and hashes within words are skipped")
+}
+
+func TestTokenizePrompts(t *testing.T) {
+	data, err := ioutil.ReadFile("samples/prompts.md")
+	require.NoError(t, err, "Unable to read sample data file")
+	visitor := NewInteractionVisitor()
+	require.NoError(t, Tokenize(data, visitor), "The prompts in the sample file are valid")
+	require.Equal(t, 3, len(visitor.Interactions), "There are three interactions in the sample file")
+	require.Equal(t, "echo Hello", visitor.Interactions[0].Cmd, "% is a prompt in the first block")
+	require.Equal(t, "echo World", visitor.Interactions[1].Cmd, "Regular expressions can be prompts, the escaped $ is kept")
+	require.False(t, visitor.Interactions[1].RootRequired, "The regular expression is not a root prompt")
+	require.Equal(t, "id -u", visitor.Interactions[2].Cmd, "The second block specifies a root prompt")
+	require.True(t, visitor.Interactions[2].RootRequired, "The command requires root privileges")
+
+	visitor = NewInteractionVisitor()
+	rootPrompts, err := NewPrompts([]string{"#"}, true)
+	require.NoError(t, err, "# is a valid prompt")
+	visitor.Prompts = append(visitor.Prompts, rootPrompts...)
+	Tokenize(data, visitor)
+	require.Equal(t, 5, len(visitor.Interactions), "# is configured as a root prompt")
+	require.Equal(t, "id -un", visitor.Interactions[3].Cmd)
+	require.True(t, visitor.Interactions[3].RootRequired, "The command requires root privileges")
+	require.Equal(t, "id -gn", visitor.Interactions[4].Cmd, "# is a prompt in fenced code blocks")
+	require.Equal(t, []string{"root"}, visitor.Interactions[4].Response)
+	require.True(t, visitor.Interactions[4].RootRequired, "The command in the fenced code block requires root privileges")
+
+	visitor = NewInteractionVisitor()
+	visitor.Prompts, err = NewPrompts([]string{"PS C:\\>"}, false)
+	require.NoError(t, err, "Literal prompts may contain special characters")
+	Tokenize([]byte("    PS C:\\> dir\n    $ dir\n"), visitor)
+	require.Equal(t, 1, len(visitor.Interactions), "Only the configured prompt marks commands")
+	require.Equal(t, "dir", visitor.Interactions[0].Cmd)
+	require.Equal(t, []string{"$ dir"}, visitor.Interactions[0].Response, "$ is not a prompt anymore")
+	require.Error(t, Tokenize([]byte("```shell {shelldocprompt=/(/}\n$ true\n```\n"), NewInteractionVisitor()), "Invalid prompts are reported")
+
+	visitor = NewInteractionVisitor()
+	require.NoError(t, Tokenize([]byte("```shell {shelldocprompt=/\\(venv\\)\\s\\$/}\n(venv) $ true\n```\n"), visitor))
+	require.Equal(t, 1, len(visitor.Interactions), "Escaped characters in prompts of fenced code blocks are kept")
+	require.Equal(t, "true", visitor.Interactions[0].Cmd)
+
+	visitor = NewInteractionVisitor()
+	require.NoError(t, Tokenize([]byte("```powershell {shelldocprompt=\"PS C:\\>\"}\nPS C:\\> dir\n```\n"), visitor))
+	require.Equal(t, 1, len(visitor.Interactions), "Quoted prompts of fenced code blocks may contain spaces")
+	require.Equal(t, "dir", visitor.Interactions[0].Cmd)
+}
+
+func TestSplitAttributes(t *testing.T) {
+	require.Equal(t, []string{"shelldocexitcode=1", "shelldocregex"}, splitAttributes(" shelldocexitcode=1  shelldocregex"))
+	require.Equal(t, []string{"shelldocprompt=PS C:\\>", "shelldocpty"}, splitAttributes("shelldocprompt=\"PS C:\\>\" shelldocpty"),
+		"Quoted values may contain white space")
+	language, attributes := parseCodeBlockInfoString("powershell {shelldocprompt=\"PS C:\\>\"}")
+	require.Equal(t, "powershell", language)
+	require.Equal(t, map[string]string{PromptOption: "PS C:\\>"}, attributes, "The prompt is not truncated at the space")
+}
+
+func TestSplitPromptList(t *testing.T) {
+	require.Equal(t, []string{"$", "#"}, splitPromptList("$,#"))
+	require.Equal(t, []string{"/a{1,2}/", "%"}, splitPromptList("/a{1,2}/,%"), "Commas in regular expressions do not split")
+	require.Equal(t, []string{"/a,b/"}, splitPromptList("/a,b/"))
+}
+
+func TestTokenizeDirectives(t *testing.T) {
+	data, err := ioutil.ReadFile("samples/setup.md")
+	require.NoError(t, err, "Unable to read sample data file")
+	visitor := NewInteractionVisitor()
+	Tokenize(data, visitor)
+	require.Equal(t, 2, len(visitor.Interactions), "There are two visible interactions in the sample file")
+	require.Equal(t, 1, len(visitor.Setup), "There is one setup directive in the sample file")
+	require.Equal(t, 1, len(visitor.Teardown), "There is one teardown directive in the sample file")
+	setup := visitor.Setup[0]
+	require.Equal(t, SetupDirective, setup.Caption, "The directive is the caption of the interaction")
+	require.Equal(t, "export GREETING=\"Hello World\"\ncd \"$(mktemp -d)\"\necho \"setup output is ignored\"", setup.Cmd)
+	require.Equal(t, 3, setup.CommandSpan.FirstLine, "The directive starts in line 3")
+	require.Equal(t, "rm created", visitor.Teardown[0].Cmd)
+}